@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"paperless-gpt/internal/config"
 	"paperless-gpt/internal/service"
 
 	"github.com/sirupsen/logrus"
@@ -15,5 +17,19 @@ func init() {
 }
 
 func main() {
+	config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		if err := service.Rollback(os.Args[2:]); err != nil {
+			Log.Fatalf("Rollback failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		if err := service.Cache(os.Args[2:]); err != nil {
+			Log.Fatalf("Cache command failed: %v", err)
+		}
+		return
+	}
+
 	service.Start()
 }
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/logging"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	log = logging.InitLogger(config.LogLevel)
+)
+
+// pruneTarget is the share of the size limit the cache is reduced to when it is exceeded,
+// so not every following write has to prune again
+const pruneTarget = 0.9
+
+// Entry describes a cached value
+type Entry struct {
+	Key string `json:"key"`
+	// Description tells what the value was computed from, e.g. the document and model
+	Description string    `json:"description"`
+	Created     time.Time `json:"created"`
+	// LastUsed and Size are taken from the file of the entry
+	LastUsed time.Time `json:"-"`
+	Size     int64     `json:"-"`
+}
+
+// entryFile is the content of the file of an entry
+type entryFile struct {
+	Entry
+	Value json.RawMessage `json:"value"`
+}
+
+// Store is a persistent cache of json values in a directory, with one file per entry, so it is shared by running
+// instances and the cache command. Entries expire after their TTL, and the least recently used entries are removed
+// once all entries together exceed the size limit.
+type Store struct {
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+	// size is the total size of all entries, counted at Open and updated by Put
+	size  int64
+	mutex sync.Mutex
+}
+
+// Open opens the cache in dir, creating the directory if needed. A ttl of 0 means entries never expire.
+// A maxBytes of 0 disables the cache: Put stores nothing and Prune removes every entry.
+func Open(dir string, ttl time.Duration, maxBytes int64) (*Store, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating cache directory %s: %w", dir, err)
+	}
+
+	store := &Store{dir: dir, ttl: ttl, maxBytes: maxBytes}
+	entries, err := store.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		store.size += entry.Size
+	}
+	return store, nil
+}
+
+// Key derives the key of a value from everything the value depends on
+func Key(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// Dir returns the directory of the cache
+func (store *Store) Dir() string {
+	return store.dir
+}
+
+// Get reads the value of key into value and reports whether it was found. Expired and unreadable entries count as missing.
+func (store *Store) Get(key string, value interface{}) bool {
+	path := store.path(key)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Error reading cache entry %s: %v", path, err)
+		}
+		return false
+	}
+
+	var file entryFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		log.Warnf("Removing unreadable cache entry %s: %v", path, err)
+		store.remove(path, int64(len(content)))
+		return false
+	}
+	if store.expired(file.Entry) {
+		store.remove(path, int64(len(content)))
+		return false
+	}
+	if err := json.Unmarshal(file.Value, value); err != nil {
+		log.Warnf("Removing cache entry %s of another format: %v", path, err)
+		store.remove(path, int64(len(content)))
+		return false
+	}
+
+	// The modification time records the last use, which decides what is removed first
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		log.Debugf("Error updating last use of cache entry %s: %v", path, err)
+	}
+	return true
+}
+
+// Put stores value under key, replacing an existing value, and removes the least recently used entries if the
+// cache exceeds its size limit
+func (store *Store) Put(key string, description string, value interface{}) error {
+	if store.maxBytes == 0 {
+		return nil
+	}
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+	content, err := json.Marshal(entryFile{
+		Entry: Entry{Key: key, Description: description, Created: time.Now()},
+		Value: encodedValue,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+
+	path := store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating cache directory: %w", err)
+	}
+	// Entries are replaced atomically, so concurrent readers never see partial files
+	tempPath := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+	if err := os.WriteFile(tempPath, content, 0o600); err != nil {
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+
+	// The size of a replaced entry is taken while holding the mutex, so concurrent writes of the same key count it once
+	store.mutex.Lock()
+	var replacedSize int64
+	if info, err := os.Stat(path); err == nil {
+		replacedSize = info.Size()
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		store.mutex.Unlock()
+		os.Remove(tempPath)
+		return fmt.Errorf("error writing cache entry: %w", err)
+	}
+	store.size = max(store.size-replacedSize, 0) + int64(len(content))
+	exceeded := store.size > store.maxBytes
+	store.mutex.Unlock()
+
+	if exceeded {
+		if _, err := store.Prune(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Entries returns all entries of the cache, including expired ones
+func (store *Store) Entries() ([]Entry, error) {
+	var entries []Entry
+	err := filepath.WalkDir(store.dir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirEntry.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			// The entry was removed in the meantime
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var file entryFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			// Unreadable entries are listed, so they can be purged
+			file.Key = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		file.LastUsed = info.ModTime()
+		file.Size = info.Size()
+		entries = append(entries, file.Entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory %s: %w", store.dir, err)
+	}
+	return entries, nil
+}
+
+// Remove deletes every entry for which match returns true and returns the number of deleted entries and their size
+func (store *Store) Remove(match func(entry Entry) bool) (int, int64, error) {
+	entries, err := store.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	removed, removedSize := 0, int64(0)
+	for _, entry := range entries {
+		if match(entry) && store.remove(store.path(entry.Key), entry.Size) {
+			removed++
+			removedSize += entry.Size
+		}
+	}
+	return removed, removedSize, nil
+}
+
+// Prune deletes expired entries and, while the cache exceeds its size limit, the least recently used ones.
+// It returns the number of deleted entries.
+func (store *Store) Prune() (int, error) {
+	entries, err := store.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	var size int64
+	var kept []Entry
+	for _, entry := range entries {
+		if store.expired(entry) {
+			if store.remove(store.path(entry.Key), entry.Size) {
+				removed++
+			}
+			continue
+		}
+		kept = append(kept, entry)
+		size += entry.Size
+	}
+
+	if size > store.maxBytes {
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].LastUsed.Before(kept[j].LastUsed)
+		})
+		target := int64(float64(store.maxBytes) * pruneTarget)
+		for _, entry := range kept {
+			if size <= target {
+				break
+			}
+			if store.remove(store.path(entry.Key), entry.Size) {
+				removed++
+				size -= entry.Size
+			}
+		}
+	}
+
+	store.mutex.Lock()
+	store.size = size
+	store.mutex.Unlock()
+
+	if removed > 0 {
+		log.Debugf("Removed %d entries from cache %s", removed, store.dir)
+	}
+	return removed, nil
+}
+
+// expired reports whether an entry is older than the TTL of the cache
+func (store *Store) expired(entry Entry) bool {
+	return store.ttl > 0 && time.Since(entry.Created) > store.ttl
+}
+
+// path returns the file of an entry. Entries are spread over subdirectories by the beginning of their key.
+func (store *Store) path(key string) string {
+	subdirectory := key
+	if len(key) > 2 {
+		subdirectory = key[:2]
+	}
+	return filepath.Join(store.dir, subdirectory, key+".json")
+}
+
+// remove deletes the file of an entry and reports whether it existed
+func (store *Store) remove(path string, size int64) bool {
+	if err := os.Remove(path); err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Error removing cache entry %s: %v", path, err)
+		}
+		return false
+	}
+
+	store.mutex.Lock()
+	store.size = max(store.size-size, 0)
+	store.mutex.Unlock()
+	return true
+}
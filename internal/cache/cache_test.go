@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// diskSize returns the size of all entries on disk
+func diskSize(t *testing.T, store *Store) int64 {
+	t.Helper()
+	entries, err := store.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	return size
+}
+
+func TestKey(t *testing.T) {
+	if Key("llm", "gpt-4o", "prompt") != Key("llm", "gpt-4o", "prompt") {
+		t.Error("equal parts give different keys")
+	}
+	if Key("llm", "a", "bc") == Key("llm", "ab", "c") {
+		t.Error("parts are not separated")
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	store, err := Open(t.TempDir(), 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var value string
+	if store.Get("missing", &value) {
+		t.Fatal("found missing key")
+	}
+	if err := store.Put("key", "description", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Get("key", &value) || value != "value" {
+		t.Fatalf("Get() = %q", value)
+	}
+
+	var number int
+	if store.Get("key", &number) {
+		t.Error("found value of another format")
+	}
+	if store.Get("key", &value) {
+		t.Error("entry of another format was not removed")
+	}
+}
+
+func TestPutReplacedSize(t *testing.T) {
+	store, err := Open(t.TempDir(), 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{"short", strings.Repeat("long value ", 100), "short again"}
+	for _, value := range values {
+		if err := store.Put("key", "description", value); err != nil {
+			t.Fatal(err)
+		}
+		if size := diskSize(t, store); store.size != size {
+			t.Errorf("after replacing the entry with %d bytes the size is %d, but %d on disk", len(value), store.size, size)
+		}
+	}
+
+	reopened, err := Open(store.Dir(), 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.size != store.size {
+		t.Errorf("size %d after opening, %d before", reopened.size, store.size)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	dir := t.TempDir()
+	enabled, err := Open(dir, 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enabled.Put("old", "description", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	disabled, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disabled.Put("new", "description", "value"); err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if disabled.Get("new", &value) {
+		t.Error("disabled cache stored a value")
+	}
+
+	removed, err := disabled.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := disabled.Entries(); removed != 1 || len(entries) != 0 {
+		t.Errorf("Prune() removed %d entries, %d are left", removed, len(entries))
+	}
+}
+
+func TestEviction(t *testing.T) {
+	store, err := Open(t.TempDir(), 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := strings.Repeat("x", 1000)
+	keys := []string{"a1", "b2", "c3", "d4"}
+	for i, key := range keys {
+		if err := store.Put(key, "description", value); err != nil {
+			t.Fatal(err)
+		}
+		// The oldest use first, except for a1, which is used last
+		lastUsed := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if key == "a1" {
+			lastUsed = time.Now()
+		}
+		if err := os.Chtimes(store.path(key), lastUsed, lastUsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Room for three entries, so the new entry exceeds the limit and the least recently used entries are removed
+	// until the cache is below pruneTarget of the limit, which leaves two entries
+	entrySize := diskSize(t, store) / int64(len(keys))
+	store.maxBytes = 3 * entrySize
+	if err := store.Put("e5", "description", value); err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	for _, key := range append(keys, "e5") {
+		var cached string
+		if store.Get(key, &cached) {
+			found = append(found, key)
+		}
+	}
+	if strings.Join(found, ",") != "a1,e5" {
+		t.Errorf("kept %v, want the most recently used a1 and e5", found)
+	}
+	if size := diskSize(t, store); store.size != size || size > store.maxBytes {
+		t.Errorf("size %d, %d on disk, limit %d", store.size, size, store.maxBytes)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	store, err := Open(t.TempDir(), time.Hour, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		created time.Time
+		expired bool
+	}{
+		{time.Now(), false},
+		{time.Now().Add(-59 * time.Minute), false},
+		{time.Now().Add(-61 * time.Minute), true},
+	}
+	for _, test := range tests {
+		if expired := store.expired(Entry{Created: test.created}); expired != test.expired {
+			t.Errorf("entry created %v ago: expired %t, want %t", time.Since(test.created).Round(time.Minute), expired, test.expired)
+		}
+	}
+
+	store.ttl = 0
+	if store.expired(Entry{Created: time.Now().Add(-1000 * time.Hour)}) {
+		t.Error("entry expired with a ttl of 0")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	store, err := Open(t.TempDir(), 0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, description := range []string{"document 1", "document 2", "document 12"} {
+		if err := store.Put(Key(description), description, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, _, err := store.Remove(func(entry Entry) bool {
+		return strings.HasSuffix(entry.Description, "2")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := store.Entries()
+	if removed != 2 || len(entries) != 1 || entries[0].Description != "document 1" {
+		t.Errorf("removed %d entries, left %+v", removed, entries)
+	}
+	if size := diskSize(t, store); store.size != size {
+		t.Errorf("size %d, %d on disk", store.size, size)
+	}
+}
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"paperless-gpt/internal/language"
 	"paperless-gpt/internal/logging"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -16,9 +21,93 @@ var (
 	OcrTag                 = os.Getenv("PAPERLESS_OCR_TAG")
 	LlmProvider            = os.Getenv("LLM_PROVIDER")
 	LlmModel               = os.Getenv("LLM_MODEL")
+	LlmBaseURL             = os.Getenv("LLM_BASE_URL")
+	LlmAPIKey              = os.Getenv("LLM_API_KEY")
+	LlmAPIKeyHeader        = os.Getenv("LLM_API_KEY_HEADER")
+	LlmExtraHeaders        = headersEnvVar("LLM_EXTRA_HEADERS")
+	LlmTemperature         = optionalFloatEnvVar("LLM_TEMPERATURE")
+	LlmTopP                = optionalFloatEnvVar("LLM_TOP_P")
+	LlmSeed                = optionalIntEnvVar("LLM_SEED")
+	LlmMaxTokens           = intEnvVar("LLM_MAX_TOKENS", 0)
+	LlmNumCtx              = intEnvVar("LLM_NUM_CTX", 0)
+	LlmKeepAlive           = os.Getenv("LLM_KEEP_ALIVE")
+	LlmContextWindow       = intEnvVar("LLM_CONTEXT_WINDOW", 0)
+	LlmMaxChunks           = intEnvVar("LLM_MAX_CHUNKS", 16)
+	LlmFallbacks           = llmBackendsEnvVar("LLM_FALLBACKS")
+	LlmBreakerThreshold    = intEnvVar("LLM_BREAKER_THRESHOLD", 3)
+	LlmBreakerCooldown     = durationEnvVar("LLM_BREAKER_COOLDOWN", 5*time.Minute)
+	AnthropicAPIKey        = os.Getenv("ANTHROPIC_API_KEY")
+	AnthropicBaseURL       = os.Getenv("ANTHROPIC_BASE_URL")
+	AzureOpenaiEndpoint    = os.Getenv("AZURE_OPENAI_ENDPOINT")
+	AzureOpenaiAPIKey      = os.Getenv("AZURE_OPENAI_API_KEY")
+	AzureOpenaiAPIVersion  = os.Getenv("AZURE_OPENAI_API_VERSION")
+	AzureOpenaiDeployments = mapEnvVar("AZURE_OPENAI_DEPLOYMENTS")
+	AzureTenantID          = os.Getenv("AZURE_TENANT_ID")
+	AzureClientID          = os.Getenv("AZURE_CLIENT_ID")
+	AzureClientSecret      = os.Getenv("AZURE_CLIENT_SECRET")
+	AzureAuthorityHost     = os.Getenv("AZURE_AUTHORITY_HOST")
+	StructuredOutput       = strings.ToLower(os.Getenv("LLM_STRUCTURED_OUTPUT")) != "false"
+	LlmRepairRetries       = intEnvVar("LLM_REPAIR_RETRIES", 2)
 	LogLevel               = strings.ToLower(os.Getenv("LOG_LEVEL"))
 	CorrespondentBlackList = splitEnvVar("CORRESPONDENT_BLACK_LIST")
 	TagBlackList           = splitEnvVar("TAG_BLACK_LIST")
+	AllowedDocumentTypes   = splitEnvVar("ALLOWED_DOCUMENT_TYPES")
+	MaxSuggestedTags       = intEnvVar("MAX_SUGGESTED_TAGS", 0)
+	ConfidenceThreshold    = optionalFloatEnvVar("CONFIDENCE_THRESHOLD")
+	NeedsReviewTag         = os.Getenv("PAPERLESS_NEEDS_REVIEW_TAG")
+	FewShotExamples        = intEnvVar("FEW_SHOT_EXAMPLES", 0)
+	FewShotSnippetTokens   = intEnvVar("FEW_SHOT_SNIPPET_TOKENS", 300)
+	ListenAddress          = os.Getenv("LISTEN_ADDRESS")
+	DataDir                = os.Getenv("DATA_DIR")
+	ReviewTags             = splitEnvVar("REVIEW_TAGS")
+	PendingTag             = os.Getenv("PAPERLESS_PENDING_TAG")
+	RejectedTag            = os.Getenv("PAPERLESS_REJECTED_TAG")
+	FailedTag              = os.Getenv("PAPERLESS_FAILED_TAG")
+	MaxDocumentAttempts    = intEnvVar("MAX_DOCUMENT_ATTEMPTS", 3)
+	DocumentRetryBackoff   = durationEnvVar("DOCUMENT_RETRY_BACKOFF", time.Minute)
+	DryRun                 = strings.ToLower(os.Getenv("DRY_RUN")) == "true"
+	DryRunReportPath       = os.Getenv("DRY_RUN_REPORT")
+	JournalPath            = os.Getenv("JOURNAL_PATH")
+	PollingInterval        = durationEnvVar("POLLING_INTERVAL", 10*time.Second)
+	WebhookSecret          = os.Getenv("WEBHOOK_SECRET")
+	APIToken               = os.Getenv("API_TOKEN")
+	ShutdownTimeout        = durationEnvVar("SHUTDOWN_TIMEOUT", 30*time.Second)
+	PaperlessTimeout       = durationEnvVar("PAPERLESS_TIMEOUT", 30*time.Second)
+	LlmTimeout             = durationEnvVar("LLM_TIMEOUT", 2*time.Minute)
+	OcrTimeout             = durationEnvVar("OCR_TIMEOUT", 10*time.Minute)
+	OcrProvider            = strings.ToLower(os.Getenv("OCR_PROVIDER"))
+	OcrLlmProvider         = strings.ToLower(os.Getenv("OCR_LLM_PROVIDER"))
+	OcrLlmModel            = os.Getenv("OCR_LLM_MODEL")
+	OcrDpi                 = intEnvVar("OCR_DPI", 150)
+	OcrMaxPages            = intEnvVar("OCR_MAX_PAGES", 0)
+	TesseractLanguages     = os.Getenv("TESSERACT_LANGUAGES")
+	EmbeddingProvider      = strings.ToLower(os.Getenv("EMBEDDING_PROVIDER"))
+	EmbeddingModel         = os.Getenv("EMBEDDING_MODEL")
+	EmbeddingMaxTokens     = intEnvVar("EMBEDDING_MAX_TOKENS", 2048)
+	EmbeddingSyncInterval  = durationEnvVar("EMBEDDING_SYNC_INTERVAL", 10*time.Minute)
+	EmbeddingIndexPath     = os.Getenv("EMBEDDING_INDEX_PATH")
+
+	CandidateTags           = intEnvVar("CANDIDATE_TAGS", 0)
+	CandidateCorrespondents = intEnvVar("CANDIDATE_CORRESPONDENTS", 0)
+	CandidateDocumentTypes  = intEnvVar("CANDIDATE_DOCUMENT_TYPES", 0)
+
+	// CACHE_MAX_MB limits the size of each cache, 0 disables caching
+	CacheDir   = os.Getenv("CACHE_DIR")
+	CacheTTL   = durationEnvVar("CACHE_TTL", 30*24*time.Hour)
+	CacheMaxMB = intEnvVar("CACHE_MAX_MB", 256)
+
+	DetectLanguage      = strings.ToLower(os.Getenv("DETECT_LANGUAGE")) == "true"
+	DetectLanguages     = splitEnvVar("DETECT_LANGUAGES")
+	LanguageCustomField = os.Getenv("LANGUAGE_CUSTOM_FIELD")
+
+	PromptsReloadInterval = durationEnvVar("PROMPTS_RELOAD_INTERVAL", 10*time.Second)
+
+	PageSize                = intEnvVar("PAGE_SIZE", 25)
+	AutoTagWorkers          = intEnvVar("AUTO_TAG_WORKERS", 1)
+	OcrWorkers              = intEnvVar("OCR_WORKERS", 1)
+	PaperlessMaxConcurrency = intEnvVar("PAPERLESS_MAX_CONCURRENCY", 4)
+	LlmMaxConcurrency       = intEnvVar("LLM_MAX_CONCURRENCY", 1)
+	TextractMaxConcurrency  = intEnvVar("TEXTRACT_MAX_CONCURRENCY", 2)
 
 	Region = os.Getenv("AWS_REGION")
 	Bucket = os.Getenv("AWS_OCR_BUCKET_NAME")
@@ -31,8 +120,12 @@ var (
 	PromptPostamble          = os.Getenv("PROMPT_POSTAMBLE")
 )
 
-func init() {
+// Load validates the environment, reads the pipelines and loads the templates of PROMPTS_DIR. It exits if the
+// configuration is invalid.
+func Load() {
 	validateEnvVars()
+	Pipelines = loadPipelines()
+	LoadTemplates()
 }
 
 // splitEnvVar splits an environment variable by commas and returns a slice of strings
@@ -44,6 +137,113 @@ func splitEnvVar(envVar string) []string {
 	return strings.Split(value, ",")
 }
 
+// durationEnvVar parses an environment variable as duration (e.g. "10s", "5m") and returns the default value if it is not set
+func durationEnvVar(envVar string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: '%s'.", envVar, value)
+	}
+	return duration
+}
+
+// optionalFloatEnvVar parses an environment variable as floating point number and returns nil if it is not set
+func optionalFloatEnvVar(envVar string) *float64 {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: '%s'.", envVar, value)
+	}
+	return &number
+}
+
+// optionalIntEnvVar parses an environment variable as integer and returns nil if it is not set
+func optionalIntEnvVar(envVar string) *int {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return nil
+	}
+	number := intEnvVar(envVar, 0)
+	return &number
+}
+
+// headersEnvVar parses an environment variable of comma separated "Name: value" pairs into HTTP headers
+func headersEnvVar(envVar string) map[string]string {
+	headers := make(map[string]string)
+	for _, header := range splitEnvVar(envVar) {
+		name, value, found := strings.Cut(header, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			log.Fatalf("Invalid header in %s: '%s', expected 'Name: value'.", envVar, header)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// LlmBackend is a model of an LLM provider, written as "provider:model"
+type LlmBackend struct {
+	Provider string
+	Model    string
+}
+
+func (backend LlmBackend) String() string {
+	return backend.Provider + ":" + backend.Model
+}
+
+// ParseLlmBackend parses a backend written as "provider:model". The model may contain colons itself, like "ollama:llama3:8b".
+func ParseLlmBackend(value string) (LlmBackend, error) {
+	provider, model, found := strings.Cut(strings.TrimSpace(value), ":")
+	if !found || provider == "" || model == "" {
+		return LlmBackend{}, fmt.Errorf("invalid LLM backend '%s', expected 'provider:model'", value)
+	}
+	return LlmBackend{Provider: strings.ToLower(provider), Model: model}, nil
+}
+
+// llmBackendsEnvVar parses an environment variable of comma separated "provider:model" backends
+func llmBackendsEnvVar(envVar string) []LlmBackend {
+	var backends []LlmBackend
+	for _, value := range splitEnvVar(envVar) {
+		backend, err := ParseLlmBackend(value)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envVar, err)
+		}
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+// mapEnvVar parses an environment variable of comma separated "key=value" pairs
+func mapEnvVar(envVar string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range splitEnvVar(envVar) {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" || strings.TrimSpace(value) == "" {
+			log.Fatalf("Invalid entry in %s: '%s', expected 'key=value'.", envVar, pair)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// intEnvVar parses an environment variable as integer and returns the default value if it is not set
+func intEnvVar(envVar string, defaultValue int) int {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: '%s'.", envVar, value)
+	}
+	return number
+}
+
 // validateEnvVars ensures all necessary environment variables are set
 func validateEnvVars() {
 	if PaperlessBaseURL == "" {
@@ -62,15 +262,92 @@ func validateEnvVars() {
 		log.Fatal("Please set the LLM_MODEL environment variable.")
 	}
 
-	if LlmProvider == "openai" && OpenaiAPIKey == "" {
-		log.Fatal("Please set the OPENAI_API_KEY environment variable for OpenAI provider.")
+	if LlmAPIKey == "" {
+		LlmAPIKey = OpenaiAPIKey
+	}
+	if LlmAPIKeyHeader == "" {
+		LlmAPIKeyHeader = "Authorization"
+	}
+
+	if AzureOpenaiAPIVersion == "" {
+		AzureOpenaiAPIVersion = "2024-10-21"
+	}
+	if AzureAuthorityHost == "" {
+		AzureAuthorityHost = "https://login.microsoftonline.com"
 	}
 
-	if Region == "" {
-		log.Fatal("missing environment variable: AWS_REGION")
+	validateLlmProvider(LlmProvider)
+	for _, fallback := range LlmFallbacks {
+		validateLlmProvider(fallback.Provider)
 	}
-	if Bucket == "" {
-		log.Fatal("missing environment variable: AWS_OCR_BUCKET_NAME")
+	if LlmBreakerThreshold < 1 {
+		log.Fatal("LLM_BREAKER_THRESHOLD must be at least 1.")
+	}
+	if LlmContextWindow < 0 {
+		log.Fatal("LLM_CONTEXT_WINDOW must not be negative.")
+	}
+	if LlmMaxChunks < 1 {
+		log.Fatal("LLM_MAX_CHUNKS must be at least 1.")
+	}
+
+	// Installations configured for Textract before OCR_PROVIDER existed keep using it
+	if OcrProvider == "" && Bucket != "" {
+		OcrProvider = "textract"
+	}
+	switch OcrProvider {
+	case "":
+		log.Infof("OCR_PROVIDER is not set, OCR is disabled.")
+	case "textract":
+		if Region == "" {
+			log.Fatal("missing environment variable: AWS_REGION")
+		}
+		if Bucket == "" {
+			log.Fatal("missing environment variable: AWS_OCR_BUCKET_NAME")
+		}
+	case "tesseract":
+		if TesseractLanguages == "" {
+			TesseractLanguages = "eng"
+		}
+	case "llm":
+		if OcrLlmProvider == "" {
+			OcrLlmProvider = strings.ToLower(LlmProvider)
+		}
+		if OcrLlmModel == "" {
+			OcrLlmModel = LlmModel
+		}
+		if OcrLlmProvider == "anthropic" {
+			log.Fatal("The anthropic provider does not support images, choose another OCR_LLM_PROVIDER.")
+		}
+		validateLlmProvider(OcrLlmProvider)
+	default:
+		log.Fatalf("Unsupported OCR_PROVIDER '%s', use textract, tesseract or llm.", OcrProvider)
+	}
+	if OcrDpi < 50 {
+		log.Fatal("OCR_DPI must be at least 50.")
+	}
+	if OcrMaxPages < 0 {
+		log.Fatal("OCR_MAX_PAGES must not be negative.")
+	}
+
+	switch EmbeddingProvider {
+	case "":
+	case "openai", "openai-compatible", "ollama", "azure":
+		if EmbeddingModel == "" {
+			switch EmbeddingProvider {
+			case "openai":
+				EmbeddingModel = "text-embedding-3-small"
+			case "ollama":
+				EmbeddingModel = "nomic-embed-text"
+			default:
+				log.Fatalf("Please set the EMBEDDING_MODEL environment variable for the %s provider.", EmbeddingProvider)
+			}
+		}
+		validateLlmProvider(EmbeddingProvider)
+	default:
+		log.Fatalf("Unsupported EMBEDDING_PROVIDER '%s', use openai, openai-compatible, ollama or azure.", EmbeddingProvider)
+	}
+	if EmbeddingMaxTokens < 1 {
+		log.Fatal("EMBEDDING_MAX_TOKENS must be at least 1.")
 	}
 
 	if AutoTag == "" {
@@ -80,11 +357,95 @@ func validateEnvVars() {
 		OcrTag = "paperless-gpt-ocr"
 	}
 
+	if ListenAddress == "" {
+		ListenAddress = ":8080"
+	}
+	if DataDir == "" {
+		DataDir = "./data"
+	}
+	if PendingTag == "" {
+		PendingTag = "paperless-gpt-pending"
+	}
+	if RejectedTag == "" {
+		RejectedTag = "paperless-gpt-rejected"
+	}
+	if DryRunReportPath == "" {
+		DryRunReportPath = filepath.Join(DataDir, "dry_run_report.jsonl")
+	}
+	if JournalPath == "" {
+		JournalPath = filepath.Join(DataDir, "journal.jsonl")
+	}
+	if EmbeddingIndexPath == "" {
+		EmbeddingIndexPath = filepath.Join(DataDir, "embeddings.json")
+	}
+	if FailedTag == "" {
+		FailedTag = "paperless-gpt-failed"
+	}
+	if ConfidenceThreshold != nil && (*ConfidenceThreshold < 0 || *ConfidenceThreshold > 1) {
+		log.Fatal("CONFIDENCE_THRESHOLD must be between 0 and 1.")
+	}
+	if NeedsReviewTag == "" {
+		NeedsReviewTag = "paperless-gpt-needs-review"
+	}
+	if FewShotExamples < 0 {
+		log.Fatal("FEW_SHOT_EXAMPLES must not be negative.")
+	}
+	if FewShotSnippetTokens < 1 {
+		log.Fatal("FEW_SHOT_SNIPPET_TOKENS must be at least 1.")
+	}
+	for _, code := range DetectLanguages {
+		if !isSupportedLanguage(code) {
+			log.Fatalf("DETECT_LANGUAGES contains the unsupported language %s.", code)
+		}
+	}
+	if LanguageCustomField != "" && !DetectLanguage {
+		log.Fatal("LANGUAGE_CUSTOM_FIELD requires DETECT_LANGUAGE=true.")
+	}
+	if PromptsReloadInterval < 0 {
+		log.Fatal("PROMPTS_RELOAD_INTERVAL must not be negative.")
+	}
+	if CacheDir == "" {
+		CacheDir = filepath.Join(DataDir, "cache")
+	}
+	if CacheTTL < 0 {
+		log.Fatal("CACHE_TTL must not be negative.")
+	}
+	if CacheMaxMB < 0 {
+		log.Fatal("CACHE_MAX_MB must not be negative.")
+	}
+	if CandidateTags < 0 || CandidateCorrespondents < 0 || CandidateDocumentTypes < 0 {
+		log.Fatal("CANDIDATE_TAGS, CANDIDATE_CORRESPONDENTS and CANDIDATE_DOCUMENT_TYPES must not be negative.")
+	}
+	if MaxDocumentAttempts < 1 {
+		log.Fatal("MAX_DOCUMENT_ATTEMPTS must be at least 1.")
+	}
+
+	if LlmRepairRetries < 0 {
+		log.Fatal("LLM_REPAIR_RETRIES must not be negative.")
+	}
+
+	if PageSize < 1 {
+		log.Fatal("PAGE_SIZE must be at least 1.")
+	}
+	if AutoTagWorkers < 1 || OcrWorkers < 1 {
+		log.Fatal("AUTO_TAG_WORKERS and OCR_WORKERS must be at least 1.")
+	}
+
 	if len(TagBlackList) == 0 {
 		TagBlackList = append(TagBlackList, OcrTag)
 	}
 }
 
+// isSupportedLanguage reports whether a language code can be detected
+func isSupportedLanguage(code string) bool {
+	for _, supported := range language.Supported() {
+		if strings.EqualFold(strings.TrimSpace(code), supported.Code) {
+			return true
+		}
+	}
+	return false
+}
+
 // getLikelyLanguage determines the likely language of the document content
 func GetLikelyLanguage() string {
 	likelyLanguage := os.Getenv("LLM_LANGUAGE")
@@ -93,3 +454,66 @@ func GetLikelyLanguage() string {
 	}
 	return strings.Title(strings.ToLower(likelyLanguage))
 }
+
+// validateLlmProvider checks the settings required by an LLM provider, used for LLM_PROVIDER and the providers of pipelines
+func validateLlmProvider(provider string) {
+	provider = strings.ToLower(provider)
+	settings := loadProviderSettings(provider)
+	switch provider {
+	case "openai":
+		if settings.APIKey == "" {
+			log.Fatalf("Please set the OPENAI_API_KEY or %s environment variable for OpenAI provider.", providerEnvVar(provider, "API_KEY"))
+		}
+
+	case "openai-compatible":
+		if settings.BaseURL == "" {
+			if provider == strings.ToLower(LlmProvider) {
+				log.Fatal("Please set the LLM_BASE_URL environment variable for the openai-compatible provider.")
+			}
+			log.Fatalf("Please set the %s environment variable for the openai-compatible provider.", providerEnvVar(provider, "BASE_URL"))
+		}
+
+	case "ollama":
+
+	case "anthropic":
+		if settings.APIKey == "" {
+			log.Fatal("Please set the ANTHROPIC_API_KEY environment variable for the Anthropic provider.")
+		}
+
+	case "azure":
+		if settings.BaseURL == "" {
+			log.Fatal("Please set the AZURE_OPENAI_ENDPOINT environment variable for the Azure provider.")
+		}
+
+		clientCredentials := 0
+		for _, value := range []string{AzureTenantID, AzureClientID, AzureClientSecret} {
+			if value != "" {
+				clientCredentials++
+			}
+		}
+		switch {
+		case settings.APIKey != "" && clientCredentials > 0:
+			log.Fatal("Set either AZURE_OPENAI_API_KEY or AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET for the Azure provider, not both.")
+		case settings.APIKey == "" && clientCredentials < 3:
+			log.Fatal("Please set AZURE_OPENAI_API_KEY or AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET for the Azure provider.")
+		}
+
+	default:
+		log.Fatalf("Unsupported LLM provider '%s', use openai, openai-compatible, ollama, anthropic or azure.", provider)
+	}
+	validateBaseURL(provider, settings.BaseURL)
+	llmProviderSettings[provider] = settings
+}
+
+// AzureUsesClientCredentials reports whether requests to Azure OpenAI are authenticated with a Microsoft Entra ID service principal instead of an API key
+func AzureUsesClientCredentials() bool {
+	return ProviderSettings("azure").APIKey == "" && AzureClientSecret != ""
+}
+
+// AzureDeployment returns the deployment of a model configured in AZURE_OPENAI_DEPLOYMENTS, or the model name if no deployment is configured
+func AzureDeployment(model string) string {
+	if deployment, found := AzureOpenaiDeployments[model]; found {
+		return deployment
+	}
+	return model
+}
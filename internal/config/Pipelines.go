@@ -0,0 +1,189 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Steps a pipeline can run, in the order they are listed in its definition
+const (
+	StepOcr      = "ocr"
+	StepClassify = "classify"
+	StepSummary  = "summary"
+)
+
+// Fields of a document a pipeline may write
+const (
+	FieldTitle         = "title"
+	FieldTags          = "tags"
+	FieldCorrespondent = "correspondent"
+	FieldDocumentType  = "document_type"
+	FieldCreatedDate   = "created_date"
+	FieldContent       = "content"
+	FieldNotes         = "notes"
+)
+
+var (
+	PipelinesFile = os.Getenv("PIPELINES_FILE")
+
+	// Pipelines maps trigger tags to the pipelines processing them, read from PIPELINES_FILE or built from the environment
+	Pipelines []PipelineDefinition
+)
+
+// PipelineDefinition describes how documents carrying the trigger tag are processed
+type PipelineDefinition struct {
+	Name            string   `json:"name"`
+	TriggerTag      string   `json:"trigger_tag"`
+	Steps           []string `json:"steps"`
+	PromptTemplate  string   `json:"prompt_template,omitempty"`
+	SummaryTemplate string   `json:"summary_template,omitempty"`
+	Fields          []string `json:"fields,omitempty"`
+	CompletionTag   string   `json:"completion_tag,omitempty"`
+	CustomField     string   `json:"custom_field,omitempty"`
+	TagBlackList    []string `json:"tag_black_list,omitempty"`
+	LlmProvider     string   `json:"llm_provider,omitempty"`
+	LlmModel        string   `json:"llm_model,omitempty"`
+	LlmFallbacks    []string `json:"llm_fallbacks,omitempty"`
+	Workers         int      `json:"workers,omitempty"`
+}
+
+// HasStep reports whether the pipeline runs the given step
+func (definition PipelineDefinition) HasStep(step string) bool {
+	return contains(definition.Steps, step)
+}
+
+// Writes reports whether the pipeline may write the given field. Without a list of fields every field may be written.
+func (definition PipelineDefinition) Writes(field string) bool {
+	return len(definition.Fields) == 0 || contains(definition.Fields, field)
+}
+
+// defaultPipelines are the auto-tagging and OCR pipelines configured by environment variables
+func defaultPipelines() []PipelineDefinition {
+	definitions := []PipelineDefinition{
+		{
+			Name:         "auto",
+			TriggerTag:   AutoTag,
+			Steps:        []string{StepClassify},
+			CustomField:  "auto_tagged",
+			TagBlackList: TagBlackList,
+			Workers:      AutoTagWorkers,
+		},
+	}
+	if OcrProvider == "" {
+		return definitions
+	}
+
+	return append(definitions,
+		PipelineDefinition{
+			// OCR only replaces the content and hands the document over to the auto-tagging pipeline
+			Name:          "ocr",
+			TriggerTag:    OcrTag,
+			Steps:         []string{StepOcr},
+			Fields:        []string{FieldContent},
+			CompletionTag: AutoTag,
+			CustomField:   "ocr_textract",
+			TagBlackList:  []string{},
+			Workers:       OcrWorkers,
+		},
+	)
+}
+
+// loadPipelines reads the pipeline definitions from PIPELINES_FILE, or uses the default pipelines if it is not set
+func loadPipelines() []PipelineDefinition {
+	if PipelinesFile == "" {
+		return defaultPipelines()
+	}
+
+	content, err := os.ReadFile(PipelinesFile)
+	if err != nil {
+		log.Fatalf("Failed to read PIPELINES_FILE: %v", err)
+	}
+
+	var definitions []PipelineDefinition
+	if err := json.Unmarshal(content, &definitions); err != nil {
+		log.Fatalf("Failed to parse PIPELINES_FILE %s: %v", PipelinesFile, err)
+	}
+	if len(definitions) == 0 {
+		log.Fatalf("PIPELINES_FILE %s does not define any pipeline.", PipelinesFile)
+	}
+
+	names := make(map[string]bool)
+	triggerTags := make(map[string]bool)
+	for i := range definitions {
+		definition := &definitions[i]
+		if definition.Name == "" || definition.TriggerTag == "" {
+			log.Fatalf("Pipeline %d in %s needs a name and a trigger_tag.", i+1, PipelinesFile)
+		}
+		if names[definition.Name] {
+			log.Fatalf("Pipeline name '%s' is used more than once.", definition.Name)
+		}
+		if triggerTags[definition.TriggerTag] {
+			log.Fatalf("Trigger tag '%s' is used by more than one pipeline.", definition.TriggerTag)
+		}
+		names[definition.Name] = true
+		triggerTags[definition.TriggerTag] = true
+
+		if len(definition.Steps) == 0 {
+			log.Fatalf("Pipeline '%s' has no steps.", definition.Name)
+		}
+		for _, step := range definition.Steps {
+			if step != StepOcr && step != StepClassify && step != StepSummary {
+				log.Fatalf("Pipeline '%s' has an unknown step '%s'.", definition.Name, step)
+			}
+		}
+		if definition.HasStep(StepOcr) && OcrProvider == "" {
+			log.Fatalf("Pipeline '%s' has an ocr step, but OCR_PROVIDER is not set.", definition.Name)
+		}
+		for _, field := range definition.Fields {
+			switch field {
+			case FieldTitle, FieldTags, FieldCorrespondent, FieldDocumentType, FieldCreatedDate, FieldContent, FieldNotes:
+			default:
+				log.Fatalf("Pipeline '%s' has an unknown field '%s'.", definition.Name, field)
+			}
+		}
+
+		if definition.LlmModel != "" && definition.LlmProvider == "" {
+			definition.LlmProvider = LlmProvider
+		}
+		if definition.LlmProvider != "" && definition.LlmModel == "" {
+			log.Fatalf("Pipeline '%s' sets llm_provider but no llm_model.", definition.Name)
+		}
+		if definition.LlmProvider != "" {
+			validateLlmProvider(definition.LlmProvider)
+		}
+		for _, value := range definition.LlmFallbacks {
+			fallback, err := ParseLlmBackend(value)
+			if err != nil {
+				log.Fatalf("Pipeline '%s': %v", definition.Name, err)
+			}
+			validateLlmProvider(fallback.Provider)
+		}
+		if definition.TagBlackList == nil {
+			// The default black list contains the OCR tag, which must not block a pipeline triggered by it
+			definition.TagBlackList = []string{}
+			for _, tag := range TagBlackList {
+				if tag != definition.TriggerTag {
+					definition.TagBlackList = append(definition.TagBlackList, tag)
+				}
+			}
+		}
+		if definition.Workers == 0 {
+			definition.Workers = 1
+		}
+		if definition.Workers < 1 {
+			log.Fatalf("Pipeline '%s' needs at least 1 worker.", definition.Name)
+		}
+	}
+
+	return definitions
+}
+
+// contains reports whether the list contains the value
+func contains(list []string, value string) bool {
+	for _, element := range list {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
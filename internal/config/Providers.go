@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// LlmProviderSettings are the connection settings and generation options of an LLM provider
+type LlmProviderSettings struct {
+	BaseURL      string
+	APIKey       string
+	APIKeyHeader string
+	ExtraHeaders map[string]string
+
+	// Generation options, left to the provider if not set
+	Temperature *float64
+	TopP        *float64
+	Seed        *int
+	MaxTokens   int
+	// NumCtx and KeepAlive only apply to Ollama
+	NumCtx    int
+	KeepAlive string
+}
+
+// GenerationKey describes the generation options and output mode, which change the answers of the provider to the
+// same prompt. It is part of the key of cached answers.
+func (settings LlmProviderSettings) GenerationKey() string {
+	key := fmt.Sprintf("max_tokens=%d num_ctx=%d structured=%t", settings.MaxTokens, settings.NumCtx, StructuredOutput)
+	if settings.Temperature != nil {
+		key += fmt.Sprintf(" temperature=%g", *settings.Temperature)
+	}
+	if settings.TopP != nil {
+		key += fmt.Sprintf(" top_p=%g", *settings.TopP)
+	}
+	if settings.Seed != nil {
+		key += fmt.Sprintf(" seed=%d", *settings.Seed)
+	}
+	return key
+}
+
+// llmProviderSettings are the settings of every provider in use by provider, filled by validateLlmProvider at startup
+var llmProviderSettings = make(map[string]LlmProviderSettings)
+
+// ProviderSettings returns the connection settings and generation options of an LLM provider
+func ProviderSettings(provider string) LlmProviderSettings {
+	provider = strings.ToLower(provider)
+	if settings, found := llmProviderSettings[provider]; found {
+		return settings
+	}
+	return loadProviderSettings(provider)
+}
+
+// providerEnvVar returns the name of the variable overriding a setting of a provider, e.g. LLM_OPENAI_COMPATIBLE_BASE_URL
+func providerEnvVar(provider string, setting string) string {
+	return "LLM_" + strings.ToUpper(strings.ReplaceAll(provider, "-", "_")) + "_" + setting
+}
+
+// loadProviderSettings reads the settings of a provider. The generic LLM_BASE_URL, LLM_API_KEY, LLM_API_KEY_HEADER
+// and LLM_EXTRA_HEADERS only configure LLM_PROVIDER, so fallbacks and other providers never inherit its server.
+// The generic generation options apply to every provider. LLM_<PROVIDER>_* variables override both.
+func loadProviderSettings(provider string) LlmProviderSettings {
+	settings := LlmProviderSettings{
+		APIKeyHeader: "Authorization",
+		ExtraHeaders: map[string]string{},
+		Temperature:  LlmTemperature,
+		TopP:         LlmTopP,
+		Seed:         LlmSeed,
+		MaxTokens:    LlmMaxTokens,
+		NumCtx:       LlmNumCtx,
+		KeepAlive:    LlmKeepAlive,
+	}
+	switch provider {
+	case "openai":
+		settings.APIKey = OpenaiAPIKey
+	case "ollama":
+		settings.BaseURL = os.Getenv("OLLAMA_HOST")
+		if settings.BaseURL == "" {
+			settings.BaseURL = "http://127.0.0.1:11434"
+		}
+	case "anthropic":
+		settings.BaseURL, settings.APIKey = AnthropicBaseURL, AnthropicAPIKey
+	case "azure":
+		settings.BaseURL, settings.APIKey = AzureOpenaiEndpoint, AzureOpenaiAPIKey
+	}
+
+	if provider == strings.ToLower(LlmProvider) {
+		if provider == "openai" || provider == "openai-compatible" {
+			if LlmBaseURL != "" {
+				settings.BaseURL = LlmBaseURL
+			}
+			settings.APIKey, settings.APIKeyHeader = LlmAPIKey, LlmAPIKeyHeader
+		}
+		settings.ExtraHeaders = LlmExtraHeaders
+	}
+
+	if value := os.Getenv(providerEnvVar(provider, "BASE_URL")); value != "" {
+		settings.BaseURL = value
+	}
+	if value := os.Getenv(providerEnvVar(provider, "API_KEY")); value != "" {
+		settings.APIKey = value
+	}
+	if value := os.Getenv(providerEnvVar(provider, "API_KEY_HEADER")); value != "" {
+		settings.APIKeyHeader = value
+	}
+	if os.Getenv(providerEnvVar(provider, "EXTRA_HEADERS")) != "" {
+		settings.ExtraHeaders = headersEnvVar(providerEnvVar(provider, "EXTRA_HEADERS"))
+	}
+
+	if temperature := optionalFloatEnvVar(providerEnvVar(provider, "TEMPERATURE")); temperature != nil {
+		settings.Temperature = temperature
+	}
+	if topP := optionalFloatEnvVar(providerEnvVar(provider, "TOP_P")); topP != nil {
+		settings.TopP = topP
+	}
+	if seed := optionalIntEnvVar(providerEnvVar(provider, "SEED")); seed != nil {
+		settings.Seed = seed
+	}
+	settings.MaxTokens = intEnvVar(providerEnvVar(provider, "MAX_TOKENS"), settings.MaxTokens)
+	settings.NumCtx = intEnvVar(providerEnvVar(provider, "NUM_CTX"), settings.NumCtx)
+	if value := os.Getenv(providerEnvVar(provider, "KEEP_ALIVE")); value != "" {
+		settings.KeepAlive = value
+	}
+	return settings
+}
+
+// validateBaseURL ensures the base URL of a provider is an absolute URL
+func validateBaseURL(provider string, baseURL string) {
+	if baseURL == "" {
+		return
+	}
+	if parsed, err := url.Parse(baseURL); err != nil || parsed.Host == "" {
+		log.Fatalf("The base URL of the %s provider must be an URL like 'https://api.example.com/v1', got '%s'.", provider, baseURL)
+	}
+}
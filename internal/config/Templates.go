@@ -1,10 +1,17 @@
 package config
 
 import (
+	"crypto/sha256"
 	_ "embed"
-	"html/template"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
 )
@@ -14,14 +21,61 @@ var (
 	//go:embed prompts/json_prompt.tmpl
 	jsonTemplate string
 
-	JsonPrompt *template.Template
+	//go:embed prompts/json_prompt_EN.tmpl
+	jsonTemplateEN string
+
+	//go:embed prompts/summary_prompt.tmpl
+	summaryTemplate string
+
+	//go:embed prompts/ocr_prompt.tmpl
+	ocrTemplate string
+
+	//go:embed prompts/chunk_prompt.tmpl
+	chunkTemplate string
+
+	promptsDir string
+
+	// JsonPrompt asks for the suggestion of a document. Its version is recorded with every applied suggestion.
+	JsonPrompt *Prompt
+
+	SummaryPrompt *Prompt
+
+	// OcrPrompt asks a vision model to transcribe a single page, used by OCR_PROVIDER=llm
+	OcrPrompt *Prompt
+
+	// ChunkPrompt asks for notes on a part of a document which is too long for the context window of the model
+	ChunkPrompt *Prompt
 )
 
-// loadTemplates loads the title and tag templates from files or uses default templates
-func init() {
+// PromptTemplate is a parsed template of the prompts directory together with its version
+type PromptTemplate struct {
+	Template *template.Template
+	Version  string
+}
+
+// Prompt is a template of the prompts directory which can be reloaded while the app is running. A reloaded template
+// is swapped in atomically, so documents in progress keep rendering the version they started with.
+type Prompt struct {
+	fileName string
+	current  atomic.Pointer[PromptTemplate]
+	// loaded is the state of the file the current template was read from, rejected the state of the last file
+	// which failed to load, so a broken file is only reported once
+	loaded   fileState
+	rejected fileState
+	mutex    sync.Mutex
+}
+
+// fileState identifies the content of a file without reading it
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// LoadTemplates loads the templates from PROMPTS_DIR and writes the embedded defaults of missing templates
+func LoadTemplates() {
 
 	// Ensure prompts directory exists
-	promptsDir := os.Getenv("PROMPTS_DIR")
+	promptsDir = os.Getenv("PROMPTS_DIR")
 
 	if promptsDir == "" {
 		log.Fatalf("Please set the PROMPTS_DIR environment variable.")
@@ -35,18 +89,163 @@ func init() {
 		log.Fatalf("Failed to create prompts directory: %v", err)
 	}
 
-	// Load json template
-	jsonTemplatePath := filepath.Join(promptsDir, "json_prompt.tmpl")
-	jsonTemplateContent, err := os.ReadFile(jsonTemplatePath)
+	var err error
+	JsonPrompt, err = loadDefaultTemplate("json_prompt.tmpl", jsonTemplate)
+	if err != nil {
+		log.Fatalf("Failed to load json template: %v", err)
+	}
+	SummaryPrompt, err = loadDefaultTemplate("summary_prompt.tmpl", summaryTemplate)
+	if err != nil {
+		log.Fatalf("Failed to load summary template: %v", err)
+	}
+	if DetectLanguage {
+		// The English variant is only written when it can be picked, so it does not clutter existing setups
+		if err := writeDefaultTemplate("json_prompt_EN.tmpl", jsonTemplateEN); err != nil {
+			log.Fatalf("Failed to write English json template: %v", err)
+		}
+	}
+	OcrPrompt, err = loadDefaultTemplate("ocr_prompt.tmpl", ocrTemplate)
+	if err != nil {
+		log.Fatalf("Failed to load ocr template: %v", err)
+	}
+	ChunkPrompt, err = loadDefaultTemplate("chunk_prompt.tmpl", chunkTemplate)
+	if err != nil {
+		log.Fatalf("Failed to load chunk template: %v", err)
+	}
+}
+
+// loadDefaultTemplate loads a template from the prompts directory and writes the embedded default first if it does not exist
+func loadDefaultTemplate(fileName string, defaultContent string) (*Prompt, error) {
+	if err := writeDefaultTemplate(fileName, defaultContent); err != nil {
+		return nil, err
+	}
+	return OpenPrompt(fileName)
+}
+
+// writeDefaultTemplate writes the embedded default of a template to the prompts directory if it does not exist
+func writeDefaultTemplate(fileName string, defaultContent string) error {
+	templatePath := filepath.Join(promptsDir, fileName)
+	if _, err := os.Stat(templatePath); err != nil {
+		log.Infof("Could not read %s, using default template: %v", templatePath, err)
+		if err := os.WriteFile(templatePath, []byte(defaultContent), os.ModePerm); err != nil {
+			return fmt.Errorf("failed to write default template to disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// LanguageVariants returns the file names of the language variants of a template of the prompts directory by their
+// upper case language code. Variants are named after the template with a language code, e.g. json_prompt_EN.tmpl for
+// json_prompt.tmpl.
+func LanguageVariants(fileName string) (map[string]string, error) {
+	extension := filepath.Ext(fileName)
+	prefix := strings.TrimSuffix(fileName, extension) + "_"
+	paths, err := filepath.Glob(filepath.Join(promptsDir, prefix+"*"+extension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list language variants of %s: %w", fileName, err)
+	}
+
+	variants := make(map[string]string)
+	for _, path := range paths {
+		variantName := filepath.Base(path)
+		code := strings.TrimSuffix(strings.TrimPrefix(variantName, prefix), extension)
+		// Other templates may share the prefix, like json_prompt_short.tmpl
+		if len(code) != 2 {
+			continue
+		}
+		variants[strings.ToUpper(code)] = variantName
+	}
+	return variants, nil
+}
+
+// NewPrompt creates a template of the prompts directory which is loaded by its first Reload
+func NewPrompt(fileName string) *Prompt {
+	return &Prompt{fileName: fileName}
+}
+
+// OpenPrompt loads a template of the prompts directory, which can be reloaded when the file changes
+func OpenPrompt(fileName string) (*Prompt, error) {
+	prompt := NewPrompt(fileName)
+	if _, err := prompt.Reload(func(*template.Template) error { return nil }); err != nil {
+		return nil, err
+	}
+	return prompt, nil
+}
+
+// FileName returns the name of the file of the template in the prompts directory
+func (prompt *Prompt) FileName() string {
+	return prompt.fileName
+}
+
+// Current returns the active version of the template. Its Template is nil if the template was never loaded.
+func (prompt *Prompt) Current() PromptTemplate {
+	if current := prompt.current.Load(); current != nil {
+		return *current
+	}
+	return PromptTemplate{}
+}
+
+// Reload parses the file of the template if it changed since it was loaded. The new template is only swapped
+// in if validate accepts it, otherwise the current one stays active and the error is returned, once per change of
+// the file. It reports whether a new version was swapped in.
+func (prompt *Prompt) Reload(validate func(*template.Template) error) (bool, error) {
+	prompt.mutex.Lock()
+	defer prompt.mutex.Unlock()
+
+	state, err := prompt.fileState()
 	if err != nil {
-		log.Infof("Could not read %s, using default template: %v", jsonTemplatePath, err)
-		jsonTemplateContent = []byte(jsonTemplate)
-		if err := os.WriteFile(jsonTemplatePath, jsonTemplateContent, os.ModePerm); err != nil {
-			log.Fatalf("Failed to write default json template to disk: %v", err)
+		// A deleted file is reported once, the current template stays active
+		if state == prompt.rejected {
+			return false, nil
 		}
+		prompt.rejected = state
+		return false, err
 	}
-	JsonPrompt, err = template.New("json").Funcs(sprig.FuncMap()).Parse(string(jsonTemplateContent))
+	if state == prompt.loaded || state == prompt.rejected {
+		return false, nil
+	}
+
+	parsedTemplate, version, err := LoadPromptTemplate(prompt.fileName)
+	if err == nil {
+		err = validate(parsedTemplate)
+	}
+	if err != nil {
+		prompt.rejected = state
+		return false, err
+	}
+
+	prompt.loaded = state
+	if version == prompt.Current().Version {
+		// Only the modification time changed
+		return false, nil
+	}
+	prompt.current.Store(&PromptTemplate{Template: parsedTemplate, Version: version})
+	return true, nil
+}
+
+// fileState returns the state of the file of the template, the zero state if it cannot be read
+func (prompt *Prompt) fileState() (fileState, error) {
+	info, err := os.Stat(filepath.Join(promptsDir, prompt.fileName))
 	if err != nil {
-		log.Fatalf("Failed to parse json template: %v", err)
+		return fileState{}, fmt.Errorf("failed to read template %s: %w", prompt.fileName, err)
 	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// LoadPromptTemplate parses a template of the prompts directory and returns it together with its version.
+// Rendering fails for variables which are not passed to the template, so typos are not silently rendered as "<no value>".
+func LoadPromptTemplate(fileName string) (*template.Template, string, error) {
+	templatePath := filepath.Join(promptsDir, fileName)
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read template %s: %w", templatePath, err)
+	}
+
+	parsedTemplate, err := template.New(fileName).Funcs(sprig.FuncMap()).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse template %s: %w", templatePath, err)
+	}
+
+	promptHash := sha256.Sum256(content)
+	return parsedTemplate, hex.EncodeToString(promptHash[:])[:12], nil
 }
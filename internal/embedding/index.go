@@ -0,0 +1,249 @@
+package embedding
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/logging"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	log = logging.InitLogger(config.LogLevel)
+)
+
+// Entry is the embedding of a document, together with the hash of the text it was computed from
+type Entry struct {
+	DocumentID  int    `json:"document_id"`
+	ContentHash string `json:"content_hash"`
+	// Vector is normalized to a length of 1, so the cosine similarity of two vectors is their dot product
+	Vector []float32 `json:"vector"`
+}
+
+// Match is a document found by a nearest neighbour lookup, with its cosine similarity between -1 and 1
+type Match struct {
+	DocumentID int     `json:"document_id"`
+	Score      float64 `json:"score"`
+}
+
+// indexFile is the content of the file of an index
+type indexFile struct {
+	Model       string    `json:"model"`
+	MaxTokens   int       `json:"max_tokens"`
+	SyncedUntil time.Time `json:"synced_until"`
+	Entries     []Entry   `json:"entries"`
+}
+
+// Index keeps the embeddings of documents by document id in memory and persists them to a json file.
+// Lookups compare the query with every document, which is fast enough for archives of some ten thousand documents.
+// Every change is written to the file before it becomes visible, so the index in memory never gets ahead of the file.
+type Index struct {
+	path        string
+	model       string
+	maxTokens   int
+	syncedUntil time.Time
+	entries     map[int]Entry
+	mutex       sync.RWMutex
+}
+
+// Open loads the index stored at path. Embeddings computed by another model than the given one can not be compared
+// with new ones, and embeddings of texts cut at another number of tokens differ from new ones, so both are discarded.
+func Open(path string, model string, maxTokens int) (*Index, error) {
+	index := &Index{
+		path:      path,
+		model:     model,
+		maxTokens: maxTokens,
+		entries:   make(map[int]Entry),
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading embedding index %s: %w", path, err)
+	}
+
+	var file indexFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("error parsing embedding index %s: %w", path, err)
+	}
+	if file.Model != model {
+		log.Warnf("Embedding index %s was built with %s, rebuilding it with %s", path, file.Model, model)
+		return index, nil
+	}
+	if file.MaxTokens != maxTokens {
+		log.Warnf("Embedding index %s was built with texts of up to %d tokens, rebuilding it with up to %d tokens", path, file.MaxTokens, maxTokens)
+		return index, nil
+	}
+
+	index.syncedUntil = file.SyncedUntil
+	for _, entry := range file.Entries {
+		index.entries[entry.DocumentID] = entry
+	}
+	log.Infof("Loaded %d embeddings from %s", len(index.entries), path)
+	return index, nil
+}
+
+// Len returns the number of documents in the index
+func (index *Index) Len() int {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	return len(index.entries)
+}
+
+// Get returns the entry of a document
+func (index *Index) Get(documentID int) (Entry, bool) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	entry, found := index.entries[documentID]
+	return entry, found
+}
+
+// Put adds or replaces the entries of documents and saves the index. The vectors are normalized.
+func (index *Index) Put(entries []Entry) error {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	updated := copyEntries(index.entries)
+	for _, entry := range entries {
+		entry.Vector = Normalize(entry.Vector)
+		updated[entry.DocumentID] = entry
+	}
+	return index.commit(index.syncedUntil, updated)
+}
+
+// Retain removes all documents which are not in documentIDs, records that all documents modified before syncedUntil
+// are in the index and saves it. It returns the number of removed documents.
+func (index *Index) Retain(documentIDs []int, syncedUntil time.Time) (int, error) {
+	keep := make(map[int]bool, len(documentIDs))
+	for _, documentID := range documentIDs {
+		keep[documentID] = true
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	updated := copyEntries(index.entries)
+	removed := 0
+	for documentID := range updated {
+		if !keep[documentID] {
+			delete(updated, documentID)
+			removed++
+		}
+	}
+	if err := index.commit(syncedUntil, updated); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// SyncedUntil returns the time up to which all modified documents are in the index
+func (index *Index) SyncedUntil() time.Time {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	return index.syncedUntil
+}
+
+// copyEntries returns a shallow copy of the entries, which is changed and committed instead of the entries in use
+func copyEntries(entries map[int]Entry) map[int]Entry {
+	copied := make(map[int]Entry, len(entries))
+	for documentID, entry := range entries {
+		copied[documentID] = entry
+	}
+	return copied
+}
+
+// Nearest returns up to count documents most similar to the vector, the most similar first. Documents for which exclude
+// returns true are skipped.
+func (index *Index) Nearest(vector []float32, count int, exclude func(documentID int) bool) []Match {
+	vector = Normalize(vector)
+
+	index.mutex.RLock()
+	matches := make([]Match, 0, len(index.entries))
+	for documentID, entry := range index.entries {
+		if len(entry.Vector) != len(vector) || (exclude != nil && exclude(documentID)) {
+			continue
+		}
+		matches = append(matches, Match{DocumentID: documentID, Score: dot(vector, entry.Vector)})
+	}
+	index.mutex.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].DocumentID < matches[j].DocumentID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > count {
+		matches = matches[:count]
+	}
+	return matches
+}
+
+// commit writes the given state to the file of the index and only then makes it the state of the index.
+// The caller must hold the write lock.
+func (index *Index) commit(syncedUntil time.Time, entries map[int]Entry) error {
+	file := indexFile{
+		Model:       index.model,
+		MaxTokens:   index.maxTokens,
+		SyncedUntil: syncedUntil,
+		Entries:     make([]Entry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		file.Entries = append(file.Entries, entry)
+	}
+	sort.Slice(file.Entries, func(i, j int) bool {
+		return file.Entries[i].DocumentID < file.Entries[j].DocumentID
+	})
+
+	content, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("error marshalling embedding index: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(index.path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory for embedding index: %w", err)
+	}
+
+	tempPath := index.path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0o600); err != nil {
+		return fmt.Errorf("error writing embedding index: %w", err)
+	}
+	if err := os.Rename(tempPath, index.path); err != nil {
+		return fmt.Errorf("error replacing embedding index: %w", err)
+	}
+
+	index.syncedUntil, index.entries = syncedUntil, entries
+	return nil
+}
+
+// Normalize returns the vector scaled to a length of 1
+func Normalize(vector []float32) []float32 {
+	length := math.Sqrt(dot(vector, vector))
+	if length == 0 {
+		return vector
+	}
+
+	normalized := make([]float32, len(vector))
+	for i, value := range vector {
+		normalized[i] = float32(float64(value) / length)
+	}
+	return normalized
+}
+
+func dot(a []float32, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
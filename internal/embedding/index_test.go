@@ -0,0 +1,129 @@
+package embedding
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.json")
+	syncedUntil := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	index, err := Open(path, "openai:text-embedding-3-small", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Put([]Entry{{DocumentID: 1, Vector: []float32{3, 4}}, {DocumentID: 2, Vector: []float32{0, 1}}, {DocumentID: 3, Vector: []float32{1, 0}}}); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := index.Retain([]int{1, 3}, syncedUntil)
+	if err != nil || removed != 1 {
+		t.Fatalf("Retain() = %d, %v", removed, err)
+	}
+
+	tests := []struct {
+		name      string
+		model     string
+		maxTokens int
+		entries   int
+	}{
+		{name: "same settings", model: "openai:text-embedding-3-small", maxTokens: 2048, entries: 2},
+		{name: "other model", model: "ollama:nomic-embed-text", maxTokens: 2048, entries: 0},
+		{name: "other token limit", model: "openai:text-embedding-3-small", maxTokens: 512, entries: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reopened, err := Open(path, test.model, test.maxTokens)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reopened.Len() != test.entries {
+				t.Fatalf("%d entries, want %d", reopened.Len(), test.entries)
+			}
+			if test.entries == 0 {
+				if !reopened.SyncedUntil().IsZero() {
+					t.Error("rebuilt index keeps the time of the last sync")
+				}
+				return
+			}
+			if !reopened.SyncedUntil().Equal(syncedUntil) {
+				t.Errorf("synced until %v, want %v", reopened.SyncedUntil(), syncedUntil)
+			}
+			entry, found := reopened.Get(1)
+			if !found || !reflect.DeepEqual(entry.Vector, []float32{0.6, 0.8}) {
+				t.Errorf("entry 1 = %+v, want the normalized vector", entry)
+			}
+		})
+	}
+}
+
+func TestIndexFailedSave(t *testing.T) {
+	dir := t.TempDir()
+	index, err := Open(filepath.Join(dir, "embeddings.json"), "model", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Put([]Entry{{DocumentID: 1, Vector: []float32{1, 0}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file in place of the directory of the index makes every save fail
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	index.path = filepath.Join(blocked, "embeddings.json")
+
+	if err := index.Put([]Entry{{DocumentID: 2, Vector: []float32{0, 1}}}); err == nil {
+		t.Fatal("Put() succeeded without saving")
+	}
+	if _, err := index.Retain(nil, time.Now()); err == nil {
+		t.Fatal("Retain() succeeded without saving")
+	}
+	if _, found := index.Get(2); found || index.Len() != 1 || !index.SyncedUntil().IsZero() {
+		t.Errorf("failed changes are visible: %d entries, synced until %v", index.Len(), index.SyncedUntil())
+	}
+}
+
+func TestNearest(t *testing.T) {
+	index, err := Open(filepath.Join(t.TempDir(), "embeddings.json"), "model", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Put([]Entry{
+		{DocumentID: 1, Vector: []float32{1, 0}},
+		{DocumentID: 2, Vector: []float32{1, 1}},
+		{DocumentID: 3, Vector: []float32{0, 1}},
+		{DocumentID: 4, Vector: []float32{-1, 0}},
+		{DocumentID: 5, Vector: []float32{1, 0, 0}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		count     int
+		exclude   func(documentID int) bool
+		documents []int
+	}{
+		{name: "most similar first", count: 10, documents: []int{1, 2, 3, 4}},
+		{name: "count", count: 2, documents: []int{1, 2}},
+		{name: "excluded", count: 2, exclude: func(documentID int) bool { return documentID == 1 }, documents: []int{2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			documents := []int{}
+			for _, match := range index.Nearest([]float32{2, 0.5}, test.count, test.exclude) {
+				documents = append(documents, match.DocumentID)
+			}
+			if !reflect.DeepEqual(documents, test.documents) {
+				t.Errorf("Nearest() = %v, want %v", documents, test.documents)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"paperless-gpt/paperless/paperless_model"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Sources of a change, recorded with every entry
+const (
+	SourceSuggestion = "suggestion"
+	SourceTags       = "tags"
+	SourceRollback   = "rollback"
+)
+
+// DocumentState is the state of a document as stored in paperless-ngx, with ids instead of names
+type DocumentState struct {
+	Title         string                        `json:"title"`
+	Tags          []int                         `json:"tags"`
+	Correspondent *int                          `json:"correspondent"`
+	DocumentType  *int                          `json:"document_type"`
+	CreatedDate   string                        `json:"created_date"`
+	Content       string                        `json:"content"`
+	CustomFields  []paperless_model.CustomField `json:"custom_fields"`
+}
+
+// CopyField sets a field, named like in the paperless-ngx api, to its value in another state.
+// It reports false for fields which are not part of the state.
+func (state *DocumentState) CopyField(field string, from DocumentState) bool {
+	switch field {
+	case "title":
+		state.Title = from.Title
+	case "tags":
+		state.Tags = from.Tags
+	case "correspondent":
+		state.Correspondent = from.Correspondent
+	case "document_type":
+		state.DocumentType = from.DocumentType
+	case "created_date":
+		state.CreatedDate = from.CreatedDate
+	case "content":
+		state.Content = from.Content
+	case "custom_fields":
+		state.CustomFields = from.CustomFields
+	default:
+		return false
+	}
+	return true
+}
+
+// Origin describes who caused a change of a document
+type Origin struct {
+	Source          string                          `json:"source"`
+	Model           string                          `json:"model,omitempty"`
+	PromptVersion   string                          `json:"prompt_version,omitempty"`
+	CreatedEntities []paperless_model.CreatedEntity `json:"created_entities,omitempty"`
+}
+
+// Entry records the state of a document right before paperless-gpt changed it
+type Entry struct {
+	Timestamp  time.Time              `json:"timestamp"`
+	DocumentID int                    `json:"document_id"`
+	Origin                            // embedded, so the origin fields are stored flat in the entry
+	Previous   DocumentState          `json:"previous"`
+	Changes    map[string]interface{} `json:"changes"`
+}
+
+// Journal is an append-only json lines file of entries
+type Journal struct {
+	path  string
+	mutex sync.Mutex
+}
+
+// New creates a journal writing to the given file
+func New(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Append writes an entry to the end of the journal
+func (journal *Journal) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error marshalling journal entry of document %d: %w", entry.DocumentID, err)
+	}
+
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(journal.path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory for journal: %w", err)
+	}
+
+	journalFile, err := os.OpenFile(journal.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening journal: %w", err)
+	}
+	defer journalFile.Close()
+
+	if _, err := journalFile.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	// The journal is the only way back, so make sure the entry is on disk before the document is changed
+	return journalFile.Sync()
+}
+
+// ReadAll returns all entries of the journal in the order they were written
+func (journal *Journal) ReadAll() ([]Entry, error) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	journalFile, err := os.Open(journal.path)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal: %w", err)
+	}
+	defer journalFile.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(journalFile)
+	// Entries contain the full content of documents, which easily exceeds the default line limit
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing journal line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,103 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxWords limits how much of a long document is read, the beginning is enough to tell its language
+	maxWords = 2000
+	// minMatches is the number of frequent words a text needs before its language is trusted
+	minMatches = 3
+	// minMargin is how many times more frequent words the detected language needs than the next most likely one
+	minMargin = 1.5
+)
+
+// Language is a language which can be detected, identified by its ISO 639-1 code in upper case like in json_prompt_EN.tmpl
+type Language struct {
+	Code string
+	// Name is the English name, as passed to the prompts
+	Name string
+}
+
+// profile are the most frequent words of a language. Words shared by several languages count for each of them.
+type profile struct {
+	language Language
+	words    map[string]bool
+}
+
+var profiles = []profile{
+	newProfile("DE", "German", "der die das und ist nicht ein eine einen einem einer sie ich wir ihr ihre ihren mit von den dem des zu im auf für sich auch es als bei nach wird werden wurde sind oder aus an wie dass noch über vom zum zur bitte sehr geehrte geehrter damen herren mit freundlichen grüßen rechnung betrag datum"),
+	newProfile("EN", "English", "the and is are was were be been of to in that it for on with as at by this from or an not have has had you your we our they their will would can which there please dear sincerely regards invoice amount date payment account"),
+	newProfile("FR", "French", "le la les des du un une et est sont pas pour dans que qui sur au aux ce cette ces vous votre vos nous notre il elle ils avec par ou mais plus été être avoir madame monsieur cordialement veuillez agréer facture montant paiement"),
+	newProfile("ES", "Spanish", "el la los las del un una y es son que en por para con no se su sus al lo como más pero muy este esta usted estimado saludos atentamente factura importe fecha pago"),
+	newProfile("IT", "Italian", "il lo la gli le di del della dei delle un una e è sono che per con non si suo sua al alla come più ma questo questa gentile cordiali saluti distinti fattura importo data pagamento"),
+	newProfile("NL", "Dutch", "de het een en is zijn van in op te dat die voor met niet aan er ook als bij uit om naar wij u uw ons onze geachte heer mevrouw vriendelijke groet groeten factuur bedrag datum betaling"),
+}
+
+func newProfile(code string, name string, words string) profile {
+	wordSet := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		wordSet[word] = true
+	}
+	return profile{language: Language{Code: code, Name: name}, words: wordSet}
+}
+
+// Supported returns all languages which can be detected
+func Supported() []Language {
+	languages := make([]Language, 0, len(profiles))
+	for _, profile := range profiles {
+		languages = append(languages, profile.language)
+	}
+	return languages
+}
+
+// Detect identifies the language of a text by counting its words which are frequent words of a language.
+// Only the given language codes are considered, all supported languages if none are given. It reports false
+// for texts which are too short or too mixed to tell.
+func Detect(text string, codes ...string) (Language, bool) {
+	matches := make([]int, len(profiles))
+	wordCount := 0
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if wordCount == maxWords {
+			break
+		}
+		wordCount++
+		for i, profile := range profiles {
+			if profile.words[word] {
+				matches[i]++
+			}
+		}
+	}
+
+	best, second := -1, 0
+	for i, profile := range profiles {
+		if len(codes) > 0 && !containsCode(codes, profile.language.Code) {
+			continue
+		}
+		if best == -1 || matches[i] > matches[best] {
+			if best != -1 {
+				second = matches[best]
+			}
+			best = i
+		} else if matches[i] > second {
+			second = matches[i]
+		}
+	}
+
+	if best == -1 || matches[best] < minMatches || float64(matches[best]) < float64(second)*minMargin {
+		return Language{}, false
+	}
+	return profiles[best].language, true
+}
+
+// containsCode reports whether codes contains code, ignoring case
+func containsCode(codes []string, code string) bool {
+	for _, candidate := range codes {
+		if strings.EqualFold(strings.TrimSpace(candidate), code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package language
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		codes []string
+		code  string
+		found bool
+	}{
+		{
+			name:  "German",
+			text:  "Sehr geehrte Damen und Herren, anbei erhalten Sie die Rechnung für den Monat März. Mit freundlichen Grüßen",
+			code:  "DE",
+			found: true,
+		},
+		{
+			name:  "English",
+			text:  "Dear customer, please find attached the invoice for your order. The amount will be charged to your account.",
+			code:  "EN",
+			found: true,
+		},
+		{
+			name:  "French",
+			text:  "Madame, Monsieur, veuillez trouver ci-joint la facture de votre commande. Le montant sera prélevé sur votre compte.",
+			code:  "FR",
+			found: true,
+		},
+		{
+			name:  "Spanish",
+			text:  "Estimado cliente, le enviamos la factura de su pedido. El importe se cargará en su cuenta. Atentamente",
+			code:  "ES",
+			found: true,
+		},
+		{
+			name:  "Italian",
+			text:  "Gentile cliente, in allegato la fattura del suo ordine. L'importo sarà addebitato sul suo conto. Cordiali saluti",
+			code:  "IT",
+			found: true,
+		},
+		{
+			name:  "Dutch",
+			text:  "Geachte heer, hierbij ontvangt u de factuur voor uw bestelling. Het bedrag wordt van uw rekening afgeschreven. Met vriendelijke groet",
+			code:  "NL",
+			found: true,
+		},
+		{
+			name: "too short",
+			text: "Rechnung 12345",
+		},
+		{
+			name: "no words",
+			text: "12345 67,89 € 01.02.2024",
+		},
+		{
+			name: "mixed",
+			text: "the and is der die das",
+		},
+		{
+			name:  "restricted to other languages",
+			text:  "Dear customer, please find attached the invoice for your order.",
+			codes: []string{"DE", "FR"},
+		},
+		{
+			name:  "codes ignore case and spaces",
+			text:  "Sehr geehrte Damen und Herren, anbei die Rechnung. Mit freundlichen Grüßen",
+			codes: []string{"en", " de "},
+			code:  "DE",
+			found: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			detected, found := Detect(test.text, test.codes...)
+			if found != test.found || detected.Code != test.code {
+				t.Errorf("Detect() = %q, %t, want %q, %t", detected.Code, found, test.code, test.found)
+			}
+		})
+	}
+}
+
+func TestDetectReadsBeginning(t *testing.T) {
+	german := strings.Repeat("Die Rechnung ist nicht bezahlt. ", maxWords/5)
+	english := strings.Repeat("The invoice was not paid and is overdue. ", maxWords)
+
+	detected, found := Detect(german + english)
+	if !found || detected.Code != "DE" {
+		t.Errorf("Detect() = %q, %t, want the language of the first %d words", detected.Code, found, maxWords)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, language := range Supported() {
+		if language.Code != strings.ToUpper(language.Code) || len(language.Code) != 2 || language.Name == "" {
+			t.Errorf("invalid language %+v", language)
+		}
+		if seen[language.Code] {
+			t.Errorf("language %s is supported twice", language.Code)
+		}
+		seen[language.Code] = true
+	}
+}
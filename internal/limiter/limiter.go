@@ -0,0 +1,37 @@
+package limiter
+
+import "context"
+
+// Limiter bounds the number of concurrent calls to a backend
+type Limiter struct {
+	slots chan struct{}
+}
+
+// New creates a limiter allowing maxConcurrency concurrent calls. A value below 1 disables the limit.
+func New(maxConcurrency int) *Limiter {
+	if maxConcurrency < 1 {
+		return &Limiter{}
+	}
+	return &Limiter{slots: make(chan struct{}, maxConcurrency)}
+}
+
+// Acquire blocks until a slot is free or the context is done
+func (limiter *Limiter) Acquire(ctx context.Context) error {
+	if limiter.slots == nil {
+		return nil
+	}
+	select {
+	case limiter.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (limiter *Limiter) Release() {
+	if limiter.slots == nil {
+		return
+	}
+	<-limiter.slots
+}
@@ -9,3 +9,29 @@ type GenerateSuggestionsRequest struct {
 	GenerateTags           bool                       `json:"generate_tags,omitempty"`
 	GenerateCorrespondents bool                       `json:"generate_correspondents,omitempty"`
 }
+
+// UpdateSuggestionRequest is the request payload for editing a pending suggestion. Only given fields are changed.
+type UpdateSuggestionRequest struct {
+	Title         *string   `json:"title,omitempty"`
+	Correspondent *string   `json:"correspondent,omitempty"`
+	DocumentType  *string   `json:"document_type,omitempty"`
+	Date          *string   `json:"created_date,omitempty"`
+	Tags          *[]string `json:"tags,omitempty"`
+}
+
+// BulkSuggestionRequest is the request payload for approving or rejecting several pending suggestions at once
+type BulkSuggestionRequest struct {
+	DocumentIDs []int `json:"ids"`
+}
+
+// BulkSuggestionResult is the outcome of a bulk action for a single document
+type BulkSuggestionResult struct {
+	DocumentID int    `json:"id"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SearchResult is a document found by the semantic search, with its similarity to the query between -1 and 1
+type SearchResult struct {
+	Document paperless_model.Document `json:"document"`
+	Score    float64                  `json:"score"`
+}
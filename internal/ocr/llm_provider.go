@@ -0,0 +1,91 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"paperless-gpt/internal/config"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// LlmOcr transcribes documents with a multimodal LLM. PDFs are rendered to one image per page with pdftoppm,
+// images are sent as they are. It is registered as OCR provider "llm" by the service, which owns the LLM backends.
+type LlmOcr struct {
+	model    llms.Model
+	provider string
+	prompt   *config.Prompt
+}
+
+// NewLlmOcr creates an OCR backend sending pages to the model, which must accept images.
+// The provider decides how images are attached to a request.
+func NewLlmOcr(model llms.Model, provider string, prompt *config.Prompt) *LlmOcr {
+	return &LlmOcr{model: model, provider: provider, prompt: prompt}
+}
+
+// ProcessDocument transcribes a document page by page. The whole document is aborted after OCR_TIMEOUT or when ctx is cancelled.
+func (llmOcr *LlmOcr) ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.OcrTimeout)
+	defer cancel()
+
+	images, err := renderPages(ctx, docBytes, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render document: %w", err)
+	}
+	log.Infof("Transcribing %d pages", len(images))
+
+	pages := make([]Page, 0, len(images))
+	for i, image := range images {
+		pageText, err := llmOcr.transcribePage(ctx, image, i+1, len(images))
+		if err != nil {
+			return nil, fmt.Errorf("failed to transcribe page %d: %w", i+1, err)
+		}
+		log.Debugf("Transcribed page %d of %d", i+1, len(images))
+
+		// Models do not report how certain they are about the text
+		pages = append(pages, Page{Number: i + 1, Text: pageText, Confidence: UnknownConfidence})
+	}
+
+	return pages, nil
+}
+
+// transcribePage asks the model for the text of a single page
+func (llmOcr *LlmOcr) transcribePage(ctx context.Context, page pageImage, pageNumber int, pageCount int) (string, error) {
+	var promptBuffer bytes.Buffer
+	err := llmOcr.prompt.Current().Template.Execute(&promptBuffer, PromptData(pageNumber, pageCount))
+	if err != nil {
+		return "", fmt.Errorf("error executing ocr template: %v", err)
+	}
+
+	messages := []llms.MessageContent{{
+		Role: llms.ChatMessageTypeHuman,
+		Parts: []llms.ContentPart{
+			llms.TextPart(promptBuffer.String()),
+			llmOcr.imagePart(page),
+		},
+	}}
+	completion, err := llmOcr.model.GenerateContent(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+	return completion.Choices[0].Content, nil
+}
+
+// PromptData returns the data the ocr template is rendered with for a page
+func PromptData(pageNumber int, pageCount int) map[string]interface{} {
+	return map[string]interface{}{
+		"Language":   config.GetLikelyLanguage(),
+		"PageNumber": pageNumber,
+		"PageCount":  pageCount,
+	}
+}
+
+// imagePart attaches an image in the form the API of the provider expects.
+// Ollama takes raw images, the OpenAI API and compatible ones take data URLs.
+func (llmOcr *LlmOcr) imagePart(page pageImage) llms.ContentPart {
+	if llmOcr.provider == "ollama" {
+		return llms.BinaryPart(page.mimeType, page.data)
+	}
+	return llms.ImageURLPart("data:" + page.mimeType + ";base64," + base64.StdEncoding.EncodeToString(page.data))
+}
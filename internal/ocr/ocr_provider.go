@@ -0,0 +1,174 @@
+package ocr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"paperless-gpt/internal/cache"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/logging"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	log = logging.InitLogger(config.LogLevel)
+)
+
+// UnknownConfidence is the confidence of pages recognized by providers which do not report one
+const UnknownConfidence = -1
+
+// pageSeparator precedes the text of every page of documents with more than one page, unless the provider joins its pages itself
+const pageSeparator = "--- Page %d ---"
+
+// Page is the text recognized on a single page of a document
+type Page struct {
+	Number int
+	Text   string
+	// Confidence is the average confidence of the recognized text between 0 and 1, or UnknownConfidence
+	Confidence float64
+}
+
+// OCRProvider recognizes the text of a document, given as the bytes of a PDF or image together with its MIME type
+type OCRProvider interface {
+	ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error)
+}
+
+// PageJoiner is implemented by providers which combine the text of their pages differently than JoinPages does by default
+type PageJoiner interface {
+	JoinPages(pages []Page) string
+}
+
+// ProviderFactory creates an OCRProvider from the configuration
+type ProviderFactory func() (OCRProvider, error)
+
+var (
+	providers      = make(map[string]ProviderFactory)
+	providersMutex sync.Mutex
+)
+
+// Register makes a provider available under the name used by OCR_PROVIDER
+func Register(name string, factory ProviderFactory) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	providers[name] = factory
+}
+
+// NewProvider creates the registered provider of the given name. With a store, results are cached by the content
+// of the document and the settings of the provider.
+func NewProvider(name string, store *cache.Store) (OCRProvider, error) {
+	providersMutex.Lock()
+	factory, found := providers[name]
+	providersMutex.Unlock()
+	if !found {
+		return nil, fmt.Errorf("unknown OCR provider %s", name)
+	}
+
+	provider, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCR provider %s: %w", name, err)
+	}
+	if store == nil {
+		return provider, nil
+	}
+	return &cachedProvider{provider: provider, name: name, store: store}, nil
+}
+
+// JoinPages combines the text of all pages recognized by the provider. Providers implementing PageJoiner join their
+// pages themselves, the pages of other providers are separated by page headers if there is more than one page.
+func JoinPages(provider OCRProvider, pages []Page) string {
+	if joiner, ok := provider.(PageJoiner); ok {
+		return joiner.JoinPages(pages)
+	}
+	if len(pages) == 1 {
+		return strings.TrimSpace(pages[0].Text)
+	}
+
+	var text strings.Builder
+	for i, page := range pages {
+		if i > 0 {
+			text.WriteString("\n\n")
+		}
+		text.WriteString(fmt.Sprintf(pageSeparator, page.Number))
+		text.WriteString("\n\n")
+		text.WriteString(strings.TrimSpace(page.Text))
+	}
+	return text.String()
+}
+
+// AverageConfidence returns the average confidence of the pages which report one, or UnknownConfidence
+func AverageConfidence(pages []Page) float64 {
+	sum, count := 0.0, 0
+	for _, page := range pages {
+		if page.Confidence != UnknownConfidence {
+			sum += page.Confidence
+			count++
+		}
+	}
+	if count == 0 {
+		return UnknownConfidence
+	}
+	return sum / float64(count)
+}
+
+// sortPages orders pages by their number
+func sortPages(pages []Page) {
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Number < pages[j].Number
+	})
+}
+
+// providerSettings describes everything besides the document which changes the results of a provider,
+// so results recognized with other settings are not taken from the cache. It is computed for every document,
+// since the OCR prompt may be reloaded at any time.
+func providerSettings(name string) string {
+	switch name {
+	case "tesseract":
+		return fmt.Sprintf("tesseract languages=%s dpi=%d max_pages=%d", config.TesseractLanguages, config.OcrDpi, config.OcrMaxPages)
+	case "llm":
+		return fmt.Sprintf("llm provider=%s model=%s dpi=%d max_pages=%d prompt=%s %s", config.OcrLlmProvider, config.OcrLlmModel, config.OcrDpi, config.OcrMaxPages,
+			config.OcrPrompt.Current().Version, config.ProviderSettings(config.OcrLlmProvider).GenerationKey())
+	default:
+		return name
+	}
+}
+
+// cachedProvider keeps the results of documents in the persistent cache, so documents which are processed again,
+// e.g. after a crash or a failed update of paperless-ngx, are not sent to the provider again
+type cachedProvider struct {
+	provider OCRProvider
+	name     string
+	store    *cache.Store
+}
+
+// JoinPages joins the pages the way the cached provider does
+func (cached *cachedProvider) JoinPages(pages []Page) string {
+	return JoinPages(cached.provider, pages)
+}
+
+func (cached *cachedProvider) ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error) {
+	settings := providerSettings(cached.name)
+	documentHash := sha256.Sum256(docBytes)
+	key := cache.Key("ocr", settings, hex.EncodeToString(documentHash[:]))
+
+	var pages []Page
+	if cached.store.Get(key, &pages) {
+		log.Infof("Using cached OCR result of %d pages (%s)", len(pages), settings)
+		return pages, nil
+	}
+
+	pages, err := cached.provider.ProcessDocument(ctx, docBytes, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("%s, %d pages, %d bytes %s", settings, len(pages), len(docBytes), mimeType)
+	if err := cached.store.Put(key, description, pages); err != nil {
+		// The result is still valid, it just has to be recognized again next time
+		log.Warnf("Failed to cache OCR result: %v", err)
+	}
+	return pages, nil
+}
@@ -0,0 +1,44 @@
+package ocr
+
+import (
+	"context"
+	"testing"
+)
+
+// fixedProvider returns the given pages for every document
+type fixedProvider struct {
+	pages []Page
+}
+
+func (provider *fixedProvider) ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error) {
+	return provider.pages, nil
+}
+
+func TestJoinPages(t *testing.T) {
+	pages := []Page{
+		{Number: 1, Text: "First page\n"},
+		{Number: 2, Text: "Second page\n"},
+	}
+
+	tests := []struct {
+		name     string
+		provider OCRProvider
+		pages    []Page
+		text     string
+	}{
+		{name: "textract", provider: &textractProvider{}, pages: pages, text: "First page\nSecond page\n"},
+		{name: "cached textract", provider: &cachedProvider{provider: &textractProvider{}}, pages: pages, text: "First page\nSecond page\n"},
+		{name: "pages with headers", provider: &fixedProvider{}, pages: pages, text: "--- Page 1 ---\n\nFirst page\n\n--- Page 2 ---\n\nSecond page"},
+		{name: "cached pages with headers", provider: &cachedProvider{provider: &fixedProvider{}}, pages: pages, text: "--- Page 1 ---\n\nFirst page\n\n--- Page 2 ---\n\nSecond page"},
+		{name: "single page", provider: &fixedProvider{}, pages: pages[:1], text: "First page"},
+		{name: "no pages", provider: &fixedProvider{}, text: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if text := JoinPages(test.provider, test.pages); text != test.text {
+				t.Errorf("JoinPages() = %q, want %q", text, test.text)
+			}
+		})
+	}
+}
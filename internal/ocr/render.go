@@ -0,0 +1,76 @@
+package ocr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"paperless-gpt/internal/config"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageImage is a single page of a document, rendered as image
+type pageImage struct {
+	mimeType string
+	data     []byte
+}
+
+// renderPages returns one image per page of a PDF, at most OCR_MAX_PAGES. Images are returned as a single page.
+func renderPages(ctx context.Context, docBytes []byte, mimeType string) ([]pageImage, error) {
+	switch mimeType {
+	case "application/pdf":
+	case "image/png", "image/jpeg", "image/webp", "image/gif":
+		return []pageImage{{mimeType: mimeType, data: docBytes}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported file type %s", mimeType)
+	}
+
+	tempDir, err := os.MkdirTemp("", "paperless-gpt-ocr-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pdfPath := filepath.Join(tempDir, "document.pdf")
+	if err := os.WriteFile(pdfPath, docBytes, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write document: %w", err)
+	}
+
+	args := []string{"-r", strconv.Itoa(config.OcrDpi), "-png"}
+	if config.OcrMaxPages > 0 {
+		args = append(args, "-l", strconv.Itoa(config.OcrMaxPages))
+	}
+	args = append(args, pdfPath, filepath.Join(tempDir, "page"))
+
+	startTime := time.Now()
+	output, err := exec.CommandContext(ctx, "pdftoppm", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// pdftoppm pads the page numbers of all files to the same width, so sorting the names sorts the pages
+	pagePaths, err := filepath.Glob(filepath.Join(tempDir, "page-*.png"))
+	if err != nil {
+		return nil, err
+	}
+	if len(pagePaths) == 0 {
+		return nil, fmt.Errorf("pdftoppm rendered no pages")
+	}
+	sort.Strings(pagePaths)
+
+	pages := make([]pageImage, 0, len(pagePaths))
+	for _, pagePath := range pagePaths {
+		data, err := os.ReadFile(pagePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rendered page: %w", err)
+		}
+		pages = append(pages, pageImage{mimeType: "image/png", data: data})
+	}
+	log.Debugf("Rendered %d pages in %v", len(pages), time.Since(startTime))
+
+	return pages, nil
+}
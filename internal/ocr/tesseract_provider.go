@@ -0,0 +1,121 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"paperless-gpt/internal/config"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("tesseract", func() (OCRProvider, error) {
+		if _, err := exec.LookPath("tesseract"); err != nil {
+			return nil, fmt.Errorf("tesseract is not installed: %w", err)
+		}
+		return &tesseractProvider{languages: config.TesseractLanguages}, nil
+	})
+}
+
+// tesseractProvider recognizes documents locally with the tesseract binary. PDFs are rendered to images with pdftoppm first.
+type tesseractProvider struct {
+	// languages are the trained languages tesseract uses, like "deu+eng"
+	languages string
+}
+
+// ProcessDocument recognizes a document page by page. The whole document is aborted after OCR_TIMEOUT or when ctx is cancelled.
+func (provider *tesseractProvider) ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.OcrTimeout)
+	defer cancel()
+
+	images, err := renderPages(ctx, docBytes, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render document: %w", err)
+	}
+
+	pages := make([]Page, 0, len(images))
+	for i, image := range images {
+		page, err := provider.recognizePage(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to recognize page %d: %w", i+1, err)
+		}
+		page.Number = i + 1
+		pages = append(pages, page)
+	}
+
+	return pages, nil
+}
+
+// recognizePage runs tesseract on a single image and assembles the recognized words from its TSV output
+func (provider *tesseractProvider) recognizePage(ctx context.Context, image pageImage) (Page, error) {
+	cmd := exec.CommandContext(ctx, "tesseract", "stdin", "stdout", "-l", provider.languages, "tsv")
+	cmd.Stdin = bytes.NewReader(image.data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return Page{}, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseTesseractTsv(string(output)), nil
+}
+
+// parseTesseractTsv builds the text of a page from the words of a tesseract TSV report. Words of the same line are joined by spaces,
+// paragraphs are separated by empty lines. The confidence is the average confidence of all words.
+func parseTesseractTsv(tsv string) Page {
+	const (
+		columnLevel = iota
+		columnPage
+		columnBlock
+		columnParagraph
+		columnLine
+		columnWord
+		columnLeft
+		columnTop
+		columnWidth
+		columnHeight
+		columnConfidence
+		columnText
+	)
+	const levelWord = "5"
+
+	var text strings.Builder
+	var previousParagraph, previousLine string
+	confidenceSum, wordCount := 0.0, 0
+
+	for _, row := range strings.Split(tsv, "\n") {
+		columns := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if len(columns) <= columnText || columns[columnLevel] != levelWord || strings.TrimSpace(columns[columnText]) == "" {
+			continue
+		}
+
+		paragraph := columns[columnPage] + "/" + columns[columnBlock] + "/" + columns[columnParagraph]
+		line := paragraph + "/" + columns[columnLine]
+		switch {
+		case text.Len() == 0:
+		case paragraph != previousParagraph:
+			text.WriteString("\n\n")
+		case line != previousLine:
+			text.WriteString("\n")
+		default:
+			text.WriteString(" ")
+		}
+		text.WriteString(columns[columnText])
+		previousParagraph, previousLine = paragraph, line
+
+		if confidence, err := strconv.ParseFloat(columns[columnConfidence], 64); err == nil && confidence >= 0 {
+			// tesseract reports confidences between 0 and 100
+			confidenceSum += confidence / 100
+			wordCount++
+		}
+	}
+
+	page := Page{Text: text.String(), Confidence: UnknownConfidence}
+	if wordCount > 0 {
+		page.Confidence = confidenceSum / float64(wordCount)
+	}
+	return page
+}
@@ -0,0 +1,54 @@
+package ocr
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseTesseractTsv(t *testing.T) {
+	header := "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n"
+
+	tests := []struct {
+		name       string
+		tsv        string
+		text       string
+		confidence float64
+	}{
+		{
+			name: "lines and paragraphs",
+			tsv: header +
+				"1\t1\t0\t0\t0\t0\t0\t0\t100\t100\t-1\t\n" +
+				"5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t90\tRechnung\n" +
+				"5\t1\t1\t1\t1\t2\t0\t0\t10\t10\t80\tNr.\n" +
+				"5\t1\t1\t1\t2\t1\t0\t0\t10\t10\t70\t12345\n" +
+				"5\t1\t1\t2\t1\t1\t0\t0\t10\t10\t60\tBetrag\r\n",
+			text:       "Rechnung Nr.\n12345\n\nBetrag",
+			confidence: 0.75,
+		},
+		{
+			name: "empty words are skipped",
+			tsv: header +
+				"5\t1\t1\t1\t1\t1\t0\t0\t10\t10\t95\t \n" +
+				"5\t1\t1\t1\t1\t2\t0\t0\t10\t10\t85\tDatum\n",
+			text:       "Datum",
+			confidence: 0.85,
+		},
+		{
+			name:       "no words",
+			tsv:        header,
+			confidence: UnknownConfidence,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := parseTesseractTsv(test.tsv)
+			if page.Text != test.text {
+				t.Errorf("text = %q, want %q", page.Text, test.text)
+			}
+			if math.Abs(page.Confidence-test.confidence) > 1e-9 {
+				t.Errorf("confidence = %g, want %g", page.Confidence, test.confidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/limiter"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	aws_config "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/textract"
+	"github.com/aws/aws-sdk-go-v2/service/textract/types"
+)
+
+func init() {
+	Register("textract", func() (OCRProvider, error) {
+		return &textractProvider{limiter: limiter.New(config.TextractMaxConcurrency)}, nil
+	})
+}
+
+// s3CleanupTimeout bounds the deletion of the uploaded document, which runs even if processing was cancelled
+const s3CleanupTimeout = 30 * time.Second
+
+// textractPollInterval is the time between two requests for the status of a text detection job
+const textractPollInterval = 3 * time.Second
+
+// textractExtensions are the file types supported by asynchronous Textract jobs
+var textractExtensions = map[string]string{
+	"application/pdf": ".pdf",
+	"image/tiff":      ".tiff",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+// textractProvider recognizes documents with AWS Textract. Documents are uploaded to AWS_OCR_BUCKET_NAME for the duration of the job.
+type textractProvider struct {
+	// limiter bounds the number of documents processed by Textract at the same time
+	limiter *limiter.Limiter
+}
+
+// JoinPages concatenates the pages as they are, so the results match the ones from before there were other providers
+func (provider *textractProvider) JoinPages(pages []Page) string {
+	var text strings.Builder
+	for _, page := range pages {
+		text.WriteString(page.Text)
+	}
+	return text.String()
+}
+
+// ProcessDocument extracts the text of a document with Textract. The whole job is aborted after OCR_TIMEOUT or when ctx is cancelled.
+func (provider *textractProvider) ProcessDocument(ctx context.Context, docBytes []byte, mimeType string) ([]Page, error) {
+	extension, supported := textractExtensions[mimeType]
+	if !supported {
+		return nil, fmt.Errorf("textract does not support file type %s", mimeType)
+	}
+
+	if err := provider.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer provider.limiter.Release()
+
+	ctx, cancel := context.WithTimeout(ctx, config.OcrTimeout)
+	defer cancel()
+
+	// Load AWS configuration
+	awsConfig, err := aws_config.LoadDefaultConfig(ctx, aws_config.WithRegion(config.Region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	// Set up clients for S3 and Textract
+	s3Client := s3.NewFromConfig(awsConfig)
+	textractClient := textract.NewFromConfig(awsConfig)
+
+	objectKey := fmt.Sprintf("uploaded-document-%s%s", time.Now().Format("20060102-150405.000000"), extension)
+
+	// Upload the document to S3
+	if err := uploadToS3(ctx, s3Client, config.Bucket, objectKey, docBytes); err != nil {
+		return nil, fmt.Errorf("failed to upload document to S3: %v", err)
+	}
+	log.Infof("Successfully uploaded document to S3 with key: %s", objectKey)
+
+	// Ensure the file is deleted from S3 after processing, also when processing was cancelled or timed out
+	defer func() {
+		cleanupCtx, cancelCleanup := context.WithTimeout(context.WithoutCancel(ctx), s3CleanupTimeout)
+		defer cancelCleanup()
+		if err := deleteFromS3(cleanupCtx, s3Client, config.Bucket, objectKey); err != nil {
+			log.Errorf("failed to delete document from S3: %v", err)
+		} else {
+			log.Infof("Successfully deleted document from S3 with key: %s", objectKey)
+		}
+	}()
+
+	// Start OCR job on Textract
+	jobID, err := startDocumentTextDetection(ctx, textractClient, config.Bucket, objectKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start text detection job: %v", err)
+	}
+	log.Infof("Started text detection job with JobID: %s", jobID)
+
+	// Poll for job completion and retrieve results
+	blocks, err := getDocumentTextDetection(ctx, textractClient, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get text detection results: %v", err)
+	}
+
+	return pagesFromBlocks(blocks), nil
+}
+
+// deleteFromS3 deletes a file from a specified S3 bucket.
+func deleteFromS3(ctx context.Context, client *s3.Client, bucketName, objectKey string) error {
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
+// uploadToS3 uploads a byte array to a specified S3 bucket.
+func uploadToS3(ctx context.Context, client *s3.Client, bucketName, objectKey string, fileBytes []byte) error {
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(fileBytes),
+	})
+	return err
+}
+
+// startDocumentTextDetection starts an asynchronous text detection job on a PDF file stored in S3.
+func startDocumentTextDetection(ctx context.Context, client *textract.Client, bucketName, objectKey string) (string, error) {
+	input := &textract.StartDocumentTextDetectionInput{
+		DocumentLocation: &types.DocumentLocation{
+			S3Object: &types.S3Object{
+				Bucket: aws.String(bucketName),
+				Name:   aws.String(objectKey),
+			},
+		},
+	}
+
+	resp, err := client.StartDocumentTextDetection(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to start document text detection: %v", err)
+	}
+	return *resp.JobId, nil
+}
+
+// getDocumentTextDetection polls for the results of a text detection job until it is finished or ctx is done.
+func getDocumentTextDetection(ctx context.Context, client *textract.Client, jobId string) ([]types.Block, error) {
+	var blocks []types.Block
+	var nextToken *string
+
+	for {
+		resp, err := client.GetDocumentTextDetection(ctx, &textract.GetDocumentTextDetectionInput{
+			JobId:     aws.String(jobId),
+			NextToken: nextToken,
+		})
+		if err != nil {
+			log.Error("Failed to get document text detection result")
+			return nil, fmt.Errorf("failed to get document text detection result: %v", err)
+		}
+
+		if resp.JobStatus == types.JobStatusSucceeded {
+			blocks = append(blocks, resp.Blocks...)
+			if resp.NextToken == nil {
+				log.Info("OCR Job completed successfully")
+				break
+			} else {
+				log.Info("Retrieving next set of blocks...")
+				nextToken = resp.NextToken
+			}
+		} else if resp.JobStatus != types.JobStatusInProgress {
+			log.Error("OCR Job failed")
+			return nil, fmt.Errorf("document text detection job failed with status: %v", resp.JobStatus)
+		} else {
+			log.Info("OCR Job still in progress, waiting 3 seconds before retrying...")
+			select {
+			case <-ctx.Done():
+				// Textract jobs can not be cancelled, the job expires on its own
+				return nil, fmt.Errorf("stopped waiting for text detection job %s: %w", jobId, ctx.Err())
+			case <-time.After(textractPollInterval):
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+// pagesFromBlocks assembles the lines detected by Textract into pages, with the average confidence of their lines
+func pagesFromBlocks(blocks []types.Block) []Page {
+	pagesByNumber := make(map[int32]*Page)
+	lineCounts := make(map[int32]int)
+	for _, block := range blocks {
+		if block.BlockType != types.BlockTypeLine || block.Text == nil {
+			continue
+		}
+
+		pageNumber := int32(1)
+		if block.Page != nil {
+			pageNumber = *block.Page
+		}
+		page, found := pagesByNumber[pageNumber]
+		if !found {
+			page = &Page{Number: int(pageNumber)}
+			pagesByNumber[pageNumber] = page
+		}
+
+		page.Text += *block.Text + "\n"
+		if block.Confidence != nil {
+			// Textract reports confidences between 0 and 100
+			page.Confidence += float64(*block.Confidence) / 100
+			lineCounts[pageNumber]++
+		}
+	}
+
+	pages := make([]Page, 0, len(pagesByNumber))
+	for pageNumber, page := range pagesByNumber {
+		if lineCounts[pageNumber] > 0 {
+			page.Confidence /= float64(lineCounts[pageNumber])
+		} else {
+			page.Confidence = UnknownConfidence
+		}
+		pages = append(pages, *page)
+	}
+	sortPages(pages)
+	return pages
+}
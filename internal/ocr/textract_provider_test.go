@@ -0,0 +1,39 @@
+package ocr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/textract/types"
+)
+
+func TestPagesFromBlocks(t *testing.T) {
+	line := func(page int32, text string, confidence *float32) types.Block {
+		return types.Block{BlockType: types.BlockTypeLine, Page: aws.Int32(page), Text: aws.String(text), Confidence: confidence}
+	}
+
+	blocks := []types.Block{
+		{BlockType: types.BlockTypePage, Page: aws.Int32(1)},
+		line(2, "Second page", aws.Float32(50)),
+		line(1, "First line", aws.Float32(90)),
+		{BlockType: types.BlockTypeWord, Page: aws.Int32(1), Text: aws.String("First")},
+		line(1, "Second line", aws.Float32(70)),
+		line(3, "No confidence", nil),
+	}
+
+	pages := pagesFromBlocks(blocks)
+	want := []Page{
+		{Number: 1, Text: "First line\nSecond line\n", Confidence: 0.8},
+		{Number: 2, Text: "Second page\n", Confidence: 0.5},
+		{Number: 3, Text: "No confidence\n", Confidence: UnknownConfidence},
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("got %d pages, want %d", len(pages), len(want))
+	}
+	for i, page := range pages {
+		if page.Number != want[i].Number || page.Text != want[i].Text || math.Abs(page.Confidence-want[i].Confidence) > 1e-6 {
+			t.Errorf("page %d = %+v, want %+v", i, page, want[i])
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package review
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/logging"
+	"paperless-gpt/paperless/paperless_model"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	log = logging.InitLogger(config.LogLevel)
+
+	// ErrSuggestionNotFound is returned when no pending suggestion exists for a document
+	ErrSuggestionNotFound = errors.New("no pending suggestion found")
+)
+
+// PendingSuggestion is a suggestion waiting for a manual review before it is applied to paperless-ngx
+type PendingSuggestion struct {
+	Suggestion      paperless_model.DocumentSuggestion `json:"suggestion"`
+	TriggerTag      string                             `json:"trigger_tag"`
+	CustomFieldName string                             `json:"custom_field_name"`
+	CreatedAt       time.Time                          `json:"created_at"`
+	UpdatedAt       time.Time                          `json:"updated_at"`
+}
+
+// Store keeps pending suggestions by document id and persists them to a json file
+type Store struct {
+	path        string
+	suggestions map[int]PendingSuggestion
+	mutex       sync.Mutex
+}
+
+// NewStore creates a store backed by the given file and loads suggestions which were stored before
+func NewStore(path string) (*Store, error) {
+	store := &Store{
+		path:        path,
+		suggestions: make(map[int]PendingSuggestion),
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading review store %s: %w", path, err)
+	}
+
+	var suggestions []PendingSuggestion
+	if err := json.Unmarshal(content, &suggestions); err != nil {
+		return nil, fmt.Errorf("error parsing review store %s: %w", path, err)
+	}
+	for _, suggestion := range suggestions {
+		store.suggestions[suggestion.Suggestion.DocumentID] = suggestion
+	}
+
+	log.Infof("Loaded %d pending suggestions from %s", len(store.suggestions), path)
+	return store, nil
+}
+
+// Add stores a pending suggestion, replacing an existing one for the same document
+func (store *Store) Add(pending PendingSuggestion) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if pending.CreatedAt.IsZero() {
+		pending.CreatedAt = now
+	}
+	pending.UpdatedAt = now
+
+	suggestions := store.copySuggestions()
+	suggestions[pending.Suggestion.DocumentID] = pending
+	return store.commit(suggestions)
+}
+
+// List returns all pending suggestions, oldest first
+func (store *Store) List() []PendingSuggestion {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return sortedSuggestions(store.suggestions)
+}
+
+// Get returns the pending suggestion of a document
+func (store *Store) Get(documentID int) (PendingSuggestion, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pending, found := store.suggestions[documentID]
+	return pending, found
+}
+
+// Update applies the given function to the pending suggestion of a document and persists the result
+func (store *Store) Update(documentID int, update func(pending *PendingSuggestion)) (PendingSuggestion, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pending, found := store.suggestions[documentID]
+	if !found {
+		return PendingSuggestion{}, fmt.Errorf("%w for document %d", ErrSuggestionNotFound, documentID)
+	}
+
+	update(&pending)
+	pending.UpdatedAt = time.Now()
+
+	suggestions := store.copySuggestions()
+	suggestions[documentID] = pending
+	if err := store.commit(suggestions); err != nil {
+		return PendingSuggestion{}, err
+	}
+	return pending, nil
+}
+
+// Take removes the pending suggestion of a document and returns it. Of several concurrent callers only one gets the
+// suggestion, so it is approved or rejected once.
+func (store *Store) Take(documentID int) (PendingSuggestion, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pending, found := store.suggestions[documentID]
+	if !found {
+		return PendingSuggestion{}, fmt.Errorf("%w for document %d", ErrSuggestionNotFound, documentID)
+	}
+
+	suggestions := store.copySuggestions()
+	delete(suggestions, documentID)
+	if err := store.commit(suggestions); err != nil {
+		return PendingSuggestion{}, err
+	}
+	return pending, nil
+}
+
+// copySuggestions returns a copy of the suggestions, which is changed and committed instead of the suggestions in use.
+// The caller must hold the mutex.
+func (store *Store) copySuggestions() map[int]PendingSuggestion {
+	suggestions := make(map[int]PendingSuggestion, len(store.suggestions)+1)
+	for documentID, pending := range store.suggestions {
+		suggestions[documentID] = pending
+	}
+	return suggestions
+}
+
+// sortedSuggestions returns the suggestions ordered by creation time
+func sortedSuggestions(byDocument map[int]PendingSuggestion) []PendingSuggestion {
+	suggestions := make([]PendingSuggestion, 0, len(byDocument))
+	for _, pending := range byDocument {
+		suggestions = append(suggestions, pending)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		return suggestions[i].CreatedAt.Before(suggestions[j].CreatedAt)
+	})
+	return suggestions
+}
+
+// commit writes the given suggestions to a temporary file and renames it, so a crash never leaves a truncated store.
+// Only then they replace the suggestions in memory, so a failed write changes nothing. The caller must hold the mutex.
+func (store *Store) commit(suggestions map[int]PendingSuggestion) error {
+	content, err := json.MarshalIndent(sortedSuggestions(suggestions), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling review store: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory for review store: %w", err)
+	}
+
+	tempPath := store.path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0o600); err != nil {
+		return fmt.Errorf("error writing review store: %w", err)
+	}
+	if err := os.Rename(tempPath, store.path); err != nil {
+		return fmt.Errorf("error replacing review store: %w", err)
+	}
+
+	store.suggestions = suggestions
+	return nil
+}
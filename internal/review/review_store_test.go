@@ -0,0 +1,133 @@
+package review
+
+import (
+	"errors"
+	"os"
+	"paperless-gpt/paperless/paperless_model"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func pendingSuggestion(documentID int, title string) PendingSuggestion {
+	return PendingSuggestion{Suggestion: paperless_model.DocumentSuggestion{DocumentID: documentID, Title: &title}}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "review_queue.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, documentID := range []int{1, 2, 3} {
+		if err := store.Add(pendingSuggestion(documentID, "Scan")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated, err := store.Update(2, func(pending *PendingSuggestion) {
+		title := "Invoice"
+		pending.Suggestion.Title = &title
+	})
+	if err != nil || *updated.Suggestion.Title != "Invoice" {
+		t.Fatalf("Update() = %+v, %v", updated, err)
+	}
+	taken, err := store.Take(3)
+	if err != nil || taken.Suggestion.DocumentID != 3 {
+		t.Fatalf("Take() = %+v, %v", taken, err)
+	}
+	if _, err := store.Update(3, func(pending *PendingSuggestion) {}); !errors.Is(err, ErrSuggestionNotFound) {
+		t.Errorf("Update() of a taken suggestion = %v", err)
+	}
+	if _, err := store.Take(3); !errors.Is(err, ErrSuggestionNotFound) {
+		t.Errorf("Take() of a taken suggestion = %v", err)
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggestions := reopened.List()
+	if len(suggestions) != 2 || suggestions[0].Suggestion.DocumentID != 1 || suggestions[1].Suggestion.DocumentID != 2 {
+		t.Fatalf("List() = %+v, want documents 1 and 2, oldest first", suggestions)
+	}
+	if pending, found := reopened.Get(2); !found || *pending.Suggestion.Title != "Invoice" || pending.CreatedAt.IsZero() {
+		t.Errorf("Get(2) = %+v, %t", pending, found)
+	}
+}
+
+func TestStoreFailedSave(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(filepath.Join(dir, "review_queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(pendingSuggestion(1, "Scan")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file in place of the directory of the store makes every save fail
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store.path = filepath.Join(blocked, "review_queue.json")
+
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{name: "add", change: func() error { return store.Add(pendingSuggestion(2, "Scan")) }},
+		{name: "update", change: func() error {
+			_, err := store.Update(1, func(pending *PendingSuggestion) {
+				title := "Invoice"
+				pending.Suggestion.Title = &title
+			})
+			return err
+		}},
+		{name: "take", change: func() error {
+			_, err := store.Take(1)
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.change(); err == nil {
+				t.Fatal("change succeeded without saving")
+			}
+			pending, found := store.Get(1)
+			if len(store.List()) != 1 || !found || *pending.Suggestion.Title != "Scan" {
+				t.Errorf("failed change is visible: %+v", store.List())
+			}
+		})
+	}
+}
+
+func TestStoreTakeOnce(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "review_queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(pendingSuggestion(1, "Scan")); err != nil {
+		t.Fatal(err)
+	}
+
+	var taken atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Take(1); err == nil {
+				taken.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if taken.Load() != 1 {
+		t.Errorf("suggestion was taken %d times", taken.Load())
+	}
+}
@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/model"
+	"paperless-gpt/internal/review"
+	"paperless-gpt/internal/web"
+	"paperless-gpt/paperless/paperless_model"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSearchLimit is the number of documents returned by a search without limit
+	defaultSearchLimit = 10
+	// maxSearchLimit is the largest accepted limit of a search
+	maxSearchLimit = 100
+)
+
+// routes registers all HTTP endpoints of the embedded API server. All endpoints require API_TOKEN, except the
+// webhook, which is protected by WEBHOOK_SECRET.
+func (app *App) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/generate-suggestions", requireToken(app.generateSuggestionsHandler))
+	mux.HandleFunc("GET /api/suggestions", requireToken(app.listSuggestionsHandler))
+	mux.HandleFunc("GET /api/suggestions/{id}", requireToken(app.getSuggestionHandler))
+	mux.HandleFunc("PATCH /api/suggestions/{id}", requireToken(app.updateSuggestionHandler))
+	mux.HandleFunc("POST /api/suggestions/{id}/approve", requireToken(app.approveSuggestionHandler))
+	mux.HandleFunc("POST /api/suggestions/{id}/reject", requireToken(app.rejectSuggestionHandler))
+	mux.HandleFunc("POST /api/suggestions/approve", requireToken(app.bulkSuggestionHandler(app.approveSuggestion)))
+	mux.HandleFunc("POST /api/suggestions/reject", requireToken(app.bulkSuggestionHandler(app.rejectSuggestion)))
+	mux.HandleFunc("GET /api/documents/{id}/thumbnail", requireToken(app.thumbnailHandler))
+	mux.HandleFunc("GET /api/tags", requireToken(app.namesHandler(app.PaperlessClient.GetAllTags)))
+	mux.HandleFunc("GET /api/correspondents", requireToken(app.namesHandler(app.PaperlessClient.GetAllCorrespondents)))
+	mux.HandleFunc("GET /api/document-types", requireToken(app.namesHandler(app.PaperlessClient.GetAllDocumentTypes)))
+	mux.HandleFunc("POST /api/webhook", app.webhookHandler)
+	mux.HandleFunc("GET /api/search", requireToken(app.searchHandler))
+	mux.Handle("GET /", web.Handler())
+	return mux
+}
+
+// startHTTPServer starts the embedded HTTP server and blocks until it stops.
+// Once ctx is cancelled, the server stops accepting connections and waits up to SHUTDOWN_TIMEOUT for running requests.
+func (app *App) startHTTPServer(ctx context.Context, address string) error {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           app.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+
+	if config.APIToken == "" && !isLoopback(address) {
+		log.Warnf("API_TOKEN is not set, anyone who can reach %s can read and change suggestions and documents", address)
+	}
+	log.Infof("Starting HTTP server on %s", address)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server stopped: %w", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		return fmt.Errorf("error shutting down http server: %w", err)
+	}
+	return nil
+}
+
+// isLoopback reports whether a listen address only accepts connections from the local host
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// requireToken rejects requests without API_TOKEN, sent as bearer token or in the X-API-Token header.
+// Without API_TOKEN every request is accepted.
+func requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.APIToken != "" && !validToken(r) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid API token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// validToken reports whether a request carries API_TOKEN. Tokens are compared in constant time.
+func validToken(r *http.Request) bool {
+	token := r.Header.Get("X-API-Token")
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		token = bearer
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.APIToken)) == 1
+}
+
+// generateSuggestionsHandler generates suggestions for the posted documents without applying them to paperless-ngx
+func (app *App) generateSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	var request model.GenerateSuggestionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	if len(request.Documents) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no documents given"))
+		return
+	}
+
+	suggestions, err := app.generateDocumentSuggestions(r.Context(), request)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, suggestions)
+}
+
+// listSuggestionsHandler returns all suggestions waiting for a review
+func (app *App) listSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.ReviewStore.List())
+}
+
+// getSuggestionHandler returns the pending suggestion of a document
+func (app *App) getSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	documentID, err := documentIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pending, found := app.ReviewStore.Get(documentID)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w for document %d", review.ErrSuggestionNotFound, documentID))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pending)
+}
+
+// updateSuggestionHandler edits individual fields of a pending suggestion
+func (app *App) updateSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	documentID, err := documentIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var request model.UpdateSuggestionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request payload: %w", err))
+		return
+	}
+
+	pending, err := app.updatePendingSuggestion(documentID, request)
+	if err != nil {
+		writeError(w, statusForError(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pending)
+}
+
+// approveSuggestionHandler applies a pending suggestion to paperless-ngx
+func (app *App) approveSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	documentID, err := documentIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := app.approveSuggestion(r.Context(), documentID); err != nil {
+		writeError(w, statusForError(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "approved"})
+}
+
+// rejectSuggestionHandler discards a pending suggestion
+func (app *App) rejectSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	documentID, err := documentIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := app.rejectSuggestion(r.Context(), documentID); err != nil {
+		writeError(w, statusForError(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "rejected"})
+}
+
+// bulkSuggestionHandler applies the given action to every posted document id and reports the outcome per document
+func (app *App) bulkSuggestionHandler(action func(ctx context.Context, documentID int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request model.BulkSuggestionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request payload: %w", err))
+			return
+		}
+
+		results := make([]model.BulkSuggestionResult, 0, len(request.DocumentIDs))
+		for _, documentID := range request.DocumentIDs {
+			result := model.BulkSuggestionResult{DocumentID: documentID}
+			if err := action(r.Context(), documentID); err != nil {
+				log.Errorf("Bulk action failed for document %d: %v", documentID, err)
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+// thumbnailHandler proxies the preview thumbnail of a document from paperless-ngx
+func (app *App) thumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	documentID, err := documentIDFromPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	thumbnail, contentType, err := app.PaperlessClient.DownloadThumbnail(r.Context(), documentID)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := w.Write(thumbnail); err != nil {
+		log.Errorf("Error writing thumbnail of document %d: %v", documentID, err)
+	}
+}
+
+// namesHandler returns the sorted names of a paperless-ngx entity, used for autocompletion
+func (app *App) namesHandler(fetch func(ctx context.Context) (map[string]int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nameMap, err := fetch(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		names := make([]string, 0, len(nameMap))
+		for name := range nameMap {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		writeJSON(w, http.StatusOK, names)
+	}
+}
+
+// webhookHandler enqueues a document for processing, called by a paperless-ngx workflow webhook action
+func (app *App) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get("X-Webhook-Secret")
+	if config.WebhookSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(config.WebhookSecret)) != 1 {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid webhook secret"))
+		return
+	}
+
+	documentID, err := documentIDFromWebhook(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pipelineNames, err := app.enqueueDocument(r.Context(), documentID)
+	if errors.Is(err, errQueueFull) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"id": documentID, "pipelines": pipelineNames})
+}
+
+// searchHandler returns the documents most similar in meaning to the query q, limited to limit documents (default 10)
+func (app *App) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if app.embeddingIndex == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("semantic search requires EMBEDDING_PROVIDER to be set"))
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no query given"))
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q, must be between 1 and %d", value, maxSearchLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	matches, err := app.semanticSearch(r.Context(), query, limit)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	documentIDs := make([]int, 0, len(matches))
+	for _, match := range matches {
+		documentIDs = append(documentIDs, match.DocumentID)
+	}
+	documents, err := app.PaperlessClient.GetDocumentsByIDs(r.Context(), documentIDs)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	// Documents deleted since the last sync of the index are left out
+	byID := make(map[int]paperless_model.Document, len(documents))
+	for _, document := range documents {
+		byID[document.ID] = document
+	}
+	results := make([]model.SearchResult, 0, len(matches))
+	for _, match := range matches {
+		if document, found := byID[match.DocumentID]; found {
+			results = append(results, model.SearchResult{Document: document, Score: match.Score})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// documentIDFromPath parses the document id from the {id} path segment
+func documentIDFromPath(r *http.Request) (int, error) {
+	documentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid document id: %q", r.PathValue("id"))
+	}
+	return documentID, nil
+}
+
+// statusForError maps known errors to http status codes
+func statusForError(err error) int {
+	if errors.Is(err, review.ErrSuggestionNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes the given value as JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Error encoding response: %v", err)
+	}
+}
+
+// writeError logs the error and writes it as JSON response with the given status code
+func writeError(w http.ResponseWriter, status int, err error) {
+	log.Errorf("HTTP %d: %v", status, err)
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"paperless-gpt/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiToken string
+		headers  map[string]string
+		status   int
+	}{
+		{name: "no token configured", status: http.StatusOK},
+		{name: "missing token", apiToken: "secret", status: http.StatusUnauthorized},
+		{name: "bearer token", apiToken: "secret", headers: map[string]string{"Authorization": "Bearer secret"}, status: http.StatusOK},
+		{name: "header token", apiToken: "secret", headers: map[string]string{"X-API-Token": "secret"}, status: http.StatusOK},
+		{name: "wrong token", apiToken: "secret", headers: map[string]string{"X-API-Token": "guess"}, status: http.StatusUnauthorized},
+		{name: "prefix of token", apiToken: "secret", headers: map[string]string{"Authorization": "Bearer sec"}, status: http.StatusUnauthorized},
+		{name: "basic auth", apiToken: "secret", headers: map[string]string{"Authorization": "Basic secret"}, status: http.StatusUnauthorized},
+		{name: "empty bearer token", apiToken: "secret", headers: map[string]string{"Authorization": "Bearer ", "X-API-Token": "secret"}, status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous := config.APIToken
+			config.APIToken = test.apiToken
+			defer func() { config.APIToken = previous }()
+
+			handler := requireToken(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/api/generate-suggestions", nil)
+			for name, value := range test.headers {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestRoutesRequireToken(t *testing.T) {
+	previous := config.APIToken
+	config.APIToken = "secret"
+	defer func() { config.APIToken = previous }()
+
+	routes := []string{
+		"POST /api/generate-suggestions",
+		"GET /api/suggestions",
+		"GET /api/suggestions/1",
+		"PATCH /api/suggestions/1",
+		"POST /api/suggestions/1/approve",
+		"POST /api/suggestions/1/reject",
+		"POST /api/suggestions/approve",
+		"POST /api/suggestions/reject",
+		"GET /api/documents/1/thumbnail",
+		"GET /api/tags",
+		"GET /api/correspondents",
+		"GET /api/document-types",
+		"GET /api/search?q=invoice",
+	}
+
+	handler := (&App{}).routes()
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without token: status %d, want %d", route, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		address  string
+		loopback bool
+	}{
+		{":8080", false},
+		{"0.0.0.0:8080", false},
+		{"192.168.1.10:8080", false},
+		{"127.0.0.1:8080", true},
+		{"localhost:8080", true},
+		{"[::1]:8080", true},
+		{"8080", false},
+	}
+
+	for _, test := range tests {
+		if loopback := isLoopback(test.address); loopback != test.loopback {
+			t.Errorf("isLoopback(%q) = %t, want %t", test.address, loopback, test.loopback)
+		}
+	}
+}
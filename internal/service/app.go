@@ -1,23 +1,34 @@
 package service
 
 import (
-	"container/list"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"paperless-gpt/internal/cache"
+	"paperless-gpt/internal/embedding"
 	"paperless-gpt/internal/logging"
+	"paperless-gpt/internal/ocr"
+	"paperless-gpt/internal/review"
 	"paperless-gpt/paperless/paperless_model"
 	"paperless-gpt/paperless/paperless_service"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/anthropic"
 	"github.com/tmc/langchaingo/llms/ollama"
 	"github.com/tmc/langchaingo/llms/openai"
 
 	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/journal"
 )
 
 var (
@@ -26,63 +37,193 @@ var (
 	log = logging.InitLogger(config.LogLevel)
 )
 
-const maxCacheSize = 100 // Define the maximum size of the cache
-
-// CacheEntry represents a single cache entry
-type CacheEntry struct {
-	key   string
-	value string
-}
-
 // App struct to hold dependencies and cache
 type App struct {
 	PaperlessClient *paperless_service.PaperlessClient
 	LlmClient       llms.Model
-	cache           map[string]*list.Element
-	cacheList       *list.List
-	cacheMutex      sync.Mutex
+	ReviewStore     *review.Store
+	pipelines       []*pipeline
+	llmBackends     map[string]*llmBackend
+	ocrProvider     ocr.OCRProvider
+	embedder        embeddings.Embedder
+	embeddingIndex  *embedding.Index
+	inFlight        *documentSet
+	failures        *failureTracker
+	dryRunDone      *documentSet
+	llmCache        *cache.Store
+	// prompts and promptVariants are the templates of PROMPTS_DIR in use by file name, shared by the pipelines
+	prompts        map[string]*config.Prompt
+	promptVariants map[string]*promptVariants
 }
 
 func Start() {
 
 	// Initialize PaperlessClient
-	client := paperless_service.NewPaperlessClient(config.PaperlessBaseURL, config.PaperlessAPIToken)
+	client := paperless_service.NewPaperlessClient(config.PaperlessBaseURL, config.PaperlessAPIToken, config.PaperlessMaxConcurrency, config.PaperlessTimeout)
+	if config.DryRun {
+		log.Warnf("Dry-run mode enabled, no document will be changed. Changes are reported to %s", config.DryRunReportPath)
+		client.DryRun = true
+		client.DryRunReportPath = config.DryRunReportPath
+	}
+	client.Journal = journal.New(config.JournalPath)
 
-	// Initialize LlmClient
-	llm, err := createLLM()
+	// Initialize the review queue
+	reviewStore, err := review.NewStore(filepath.Join(config.DataDir, "review_queue.json"))
 	if err != nil {
-		log.Fatalf("Failed to create LlmClient client: %v", err)
+		log.Fatalf("Failed to create review store: %v", err)
+	}
+
+	// Failed attempts are kept next to the review queue, so a restart does not reset them
+	failures, err := newFailureTracker(filepath.Join(config.DataDir, "failures.json"))
+	if err != nil {
+		log.Fatalf("Failed to load failed attempts: %v", err)
 	}
 
 	// Initialize App with dependencies
 	app := &App{
 		PaperlessClient: client,
-		LlmClient:       llm,
-		cache:           make(map[string]*list.Element),
-		cacheList:       list.New(),
-		cacheMutex:      sync.Mutex{},
+		ReviewStore:     reviewStore,
+		llmBackends:     make(map[string]*llmBackend),
+		inFlight:        newDocumentSet(),
+		failures:        failures,
+		dryRunDone:      newDocumentSet(),
+		prompts:         defaultPrompts(),
+		promptVariants:  make(map[string]*promptVariants),
 	}
 
-	var wg sync.WaitGroup
-	errorChan := make(chan error, 2) // Buffered channel to capture errors
+	// Initialize the persistent caches, so OCR and LLM results survive restarts
+	ocrCache, err := openCache("ocr")
+	if err != nil {
+		log.Fatalf("Failed to open OCR cache: %v", err)
+	}
+	if app.llmCache, err = openCache("llm"); err != nil {
+		log.Fatalf("Failed to open LLM cache: %v", err)
+	}
+
+	// Initialize LlmClient, falling back to the backends of LLM_FALLBACKS if the configured one fails
+	llm, err := app.newFallbackModel(config.LlmBackend{Provider: strings.ToLower(config.LlmProvider), Model: config.LlmModel}, config.LlmFallbacks)
+	if err != nil {
+		log.Fatalf("Failed to create LlmClient client: %v", err)
+	}
+	app.LlmClient = llm
+
+	// Initialize the OCR provider. The vision model OCR is registered here, since it shares the LLM backends of the app.
+	ocr.Register("llm", func() (ocr.OCRProvider, error) {
+		ocrModel, err := app.newFallbackModel(config.LlmBackend{Provider: config.OcrLlmProvider, Model: config.OcrLlmModel}, nil)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Documents are transcribed by %s:%s", config.OcrLlmProvider, config.OcrLlmModel)
+		return ocr.NewLlmOcr(ocrModel, config.OcrLlmProvider, config.OcrPrompt), nil
+	})
+	if config.OcrProvider != "" {
+		if app.ocrProvider, err = ocr.NewProvider(config.OcrProvider, ocrCache); err != nil {
+			log.Fatalf("Failed to create OCR provider: %v", err)
+		}
+	}
+
+	// Initialize the embedding index, which is synced in the background
+	if config.EmbeddingProvider != "" {
+		if app.embedder, err = createEmbedder(config.EmbeddingProvider, config.EmbeddingModel); err != nil {
+			log.Fatalf("Failed to create embedding client: %v", err)
+		}
+		if app.embeddingIndex, err = embedding.Open(config.EmbeddingIndexPath, config.EmbeddingProvider+":"+config.EmbeddingModel, config.EmbeddingMaxTokens); err != nil {
+			log.Fatalf("Failed to open embedding index: %v", err)
+		}
+	}
+
+	// Initialize the pipelines, each triggered by its own tag
+	for _, definition := range config.Pipelines {
+		p, err := app.newPipeline(definition)
+		if err != nil {
+			log.Fatalf("Failed to create pipeline: %v", err)
+		}
+		log.Infof("Pipeline %s processes documents tagged %s with steps %v", p.name, p.tagName, definition.Steps)
+		app.pipelines = append(app.pipelines, p)
+	}
+
+	// Unknown tags are dropped when a document is updated, so a missing marker tag would hide documents from every queue
+	if err := app.ensureMarkerTags(context.Background()); err != nil {
+		log.Fatalf("Failed to create the marker tags: %v", err)
+	}
+
+	// Broken templates are reported now instead of failing every document
+	if err := app.validatePrompts(); err != nil {
+		log.Fatalf("Failed to validate prompt templates: %v", err)
+	}
 
-	wg.Add(2)
+	// ctx is cancelled by SIGINT or SIGTERM and stops fetching new documents
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	// workCtx is used for processing documents. It is only cancelled if documents in progress do not finish within SHUTDOWN_TIMEOUT.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 	go func() {
-		defer wg.Done()
-		if err := handleAutoTags(app, app.generateAutoDocumentSuggestion, config.AutoTag, "auto_tagged", config.TagBlackList); err != nil {
-			errorChan <- err
+		<-ctx.Done()
+		select {
+		case <-workCtx.Done():
+		case <-time.After(config.ShutdownTimeout):
+			log.Warnf("Documents still in progress after %v, cancelling them", config.ShutdownTimeout)
+			cancelWork()
 		}
 	}()
 
+	var wg sync.WaitGroup
+	var workerWg sync.WaitGroup
+	errorChan := make(chan error, len(app.pipelines)+1) // Buffered channel to capture errors
+
+	for _, p := range app.pipelines {
+		for i := 0; i < p.workers; i++ {
+			workerWg.Add(1)
+			go func(p *pipeline) {
+				defer workerWg.Done()
+				app.runWorker(workCtx, p)
+			}(p)
+		}
+
+		wg.Add(1)
+		go func(p *pipeline) {
+			defer wg.Done()
+			if err := handleAutoTags(ctx, app, p); err != nil {
+				errorChan <- err
+			}
+			// No more jobs are dispatched once the loop returned
+			close(p.jobs)
+		}(p)
+	}
+
+	if app.embeddingIndex != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.runEmbeddingSync(ctx)
+		}()
+	}
+
+	if config.PromptsReloadInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.runPromptReload(ctx)
+		}()
+	}
+
+	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := handleAutoTags(app, app.getOcrDocumentSuggestion, config.OcrTag, "ocr_textract", []string{}); err != nil {
+		if err := app.startHTTPServer(ctx, config.ListenAddress); err != nil {
 			errorChan <- err
+			// Without the server neither reviews nor webhooks work, so shut down the pipelines as well
+			stop()
 		}
 	}()
 
+	<-ctx.Done()
+	log.Infof("Shutting down, waiting up to %v for documents in progress", config.ShutdownTimeout)
+
 	wg.Wait()
+	workerWg.Wait()
 	close(errorChan) // Close the channel after all goroutines have completed
 
 	// Handle errors
@@ -92,103 +233,368 @@ func Start() {
 		}
 	}
 
+	log.Infof("Shutdown complete")
 }
 
-func handleAutoTags(app *App, suggestionFunc SuggestionFunc, tagName string, customFieldName string, tagBlackList []string) error {
+// handleAutoTags dispatches documents enqueued by webhooks right away and polls for tagged documents as a fallback.
+// It returns once ctx is cancelled and all dispatched documents are finished.
+func handleAutoTags(ctx context.Context, app *App, p *pipeline) error {
 	minBackoffDuration := 10 * time.Second
 	maxBackoffDuration := time.Hour
-	pollingInterval := 10 * time.Second
+	pollingInterval := config.PollingInterval
 
 	backoffDuration := minBackoffDuration
+	pollTimer := time.NewTimer(0)
+	defer pollTimer.Stop()
+
 	for {
-		processedCount, err := app.processAutoTagDocuments(suggestionFunc, tagName, customFieldName, tagBlackList)
-		if err != nil {
-			log.Errorf("Error in handleAutoTags: %v", err)
-			time.Sleep(backoffDuration)
-			backoffDuration *= 2 // Exponential backoff
-			if backoffDuration > maxBackoffDuration {
-				log.Warnf("Repeated errors in handleAutoTags detected. Setting backoff to %v", maxBackoffDuration)
-				backoffDuration = maxBackoffDuration
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case documentID := <-p.queue:
+			app.processQueuedDocuments(ctx, p, documentID)
+
+		case <-pollTimer.C:
+			processedCount, err := app.processAutoTagDocuments(ctx, p)
+			if ctx.Err() != nil {
+				return nil
 			}
-		} else {
+			if err != nil {
+				log.Errorf("Error in handleAutoTags: %v", err)
+				pollTimer.Reset(backoffDuration)
+				backoffDuration *= 2 // Exponential backoff
+				if backoffDuration > maxBackoffDuration {
+					log.Warnf("Repeated errors in handleAutoTags detected. Setting backoff to %v", maxBackoffDuration)
+					backoffDuration = maxBackoffDuration
+				}
+				continue
+			}
+
 			backoffDuration = minBackoffDuration
+			if processedCount == 0 {
+				pollTimer.Reset(pollingInterval)
+			} else {
+				pollTimer.Reset(0)
+			}
+		}
+	}
+}
+
+// handles the background auto-tagging of documents. Pages are fetched until one contains eligible documents,
+// so documents which are blacklisted or waiting for a retry never block the ones queued behind them.
+func (app *App) processAutoTagDocuments(ctx context.Context, p *pipeline) (int, error) {
+	for page := 1; ; page++ {
+		documents, hasNextPage, err := app.PaperlessClient.GetDocumentsByTagsPage(ctx, []string{p.tagName}, page, config.PageSize)
+		if err != nil {
+			return 0, fmt.Errorf("error fetching documents with autoTag: %w", err)
+		}
+
+		eligibleDocuments := make([]paperless_model.Document, 0, len(documents))
+		for _, document := range documents {
+			if app.isEligible(p, document) {
+				eligibleDocuments = append(eligibleDocuments, document)
+			}
+		}
+
+		log.Debugf("Found %d eligible of %d documents with tag %s on page %d", len(eligibleDocuments), len(documents), p.tagName, page)
+
+		if processedCount := app.dispatch(ctx, p, eligibleDocuments); processedCount > 0 {
+			return processedCount, nil
 		}
 
-		if processedCount == 0 {
-			time.Sleep(pollingInterval)
+		if !hasNextPage {
+			return 0, nil // No documents to process
 		}
 	}
 }
 
-// SuggestionFunc defines a function type that takes a document and returns a suggestion or error
-type SuggestionFunc func(ctx context.Context, document paperless_model.Document) (*paperless_model.DocumentSuggestion, error)
+// processQueuedDocuments processes the given document and all other documents waiting in the webhook queue,
+// as long as they still carry the trigger tag of the pipeline
+func (app *App) processQueuedDocuments(ctx context.Context, p *pipeline, documentID int) int {
+	documentIDs := []int{documentID}
+collect:
+	for len(documentIDs) < config.PageSize {
+		select {
+		case queuedDocumentID := <-p.queue:
+			documentIDs = append(documentIDs, queuedDocumentID)
+		default:
+			break collect
+		}
+	}
 
-// handles the background auto-tagging of documents
-func (app *App) processAutoTagDocuments(suggestionFunc SuggestionFunc, tagName string, customFieldName string, tagBlackList []string) (int, error) {
-	ctx := context.Background()
+	documents := make([]paperless_model.Document, 0, len(documentIDs))
+	for _, queuedDocumentID := range documentIDs {
+		document, err := app.PaperlessClient.GetDocument(ctx, queuedDocumentID)
+		if err != nil {
+			log.Errorf("Error fetching queued document %d: %v", queuedDocumentID, err)
+			continue
+		}
 
-	documents, err := app.PaperlessClient.GetDocumentsByTags(ctx, []string{tagName}, 1)
-	if err != nil {
-		return 0, fmt.Errorf("error fetching documents with autoTag: %w", err)
+		if !p.isTriggeredBy(document) {
+			log.Debugf("Document %d does not carry tag %s anymore, skipping.", queuedDocumentID, p.tagName)
+			continue
+		}
+		if app.isEligible(p, document) {
+			documents = append(documents, document)
+		}
 	}
 
-	if len(documents) == 0 {
-		log.Debugf("No documents with tag %s found", tagName)
-		return 0, nil // No documents to process
+	return app.dispatch(ctx, p, documents)
+}
+
+// dispatch hands the documents to the workers of the pipeline and waits until all of them are processed.
+// Documents which are already processed by any pipeline are skipped, and no further documents are handed out once ctx is cancelled.
+// It returns the number of processed documents.
+func (app *App) dispatch(ctx context.Context, p *pipeline, documents []paperless_model.Document) int {
+	var wg sync.WaitGroup
+	var resultMutex sync.Mutex
+	processedCount := 0
+
+	for _, document := range documents {
+		if !app.inFlight.tryAdd(document.ID) {
+			log.Debugf("Document %d is already being processed, skipping.", document.ID)
+			continue
+		}
+
+		wg.Add(1)
+		nextJob := job{
+			document: document,
+			done: func(count int) {
+				resultMutex.Lock()
+				defer resultMutex.Unlock()
+				processedCount += count
+				wg.Done()
+			},
+		}
+
+		select {
+		case p.jobs <- nextJob:
+		case <-ctx.Done():
+			app.inFlight.remove(document.ID)
+			wg.Done()
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
-	log.Debugf("Found at least %d remaining documents with tag %s", len(documents), tagName)
+	wg.Wait()
+	return processedCount
+}
+
+// markerTags returns the tags which replace the trigger tag of documents that failed, wait for a review or were rejected,
+// and the tag of documents with fields below the confidence threshold
+func markerTags() []string {
+	tags := []string{config.FailedTag}
+	if len(config.ReviewTags) > 0 {
+		tags = append(tags, config.PendingTag, config.RejectedTag)
+	}
+	if config.ConfidenceThreshold != nil {
+		tags = append(tags, config.NeedsReviewTag)
+	}
+	return tags
+}
+
+// ensureMarkerTags creates the marker tags which do not exist in paperless-ngx yet. In dry-run mode they are only reported.
+func (app *App) ensureMarkerTags(ctx context.Context) error {
+	available, err := app.PaperlessClient.GetAllTags(ctx)
+	if err != nil {
+		return err
+	}
 
-	// Generate suggestion for the document using the provided function pointer
-	document := documents[0]
+	for _, tag := range markerTags() {
+		if _, found := available[tag]; found {
+			continue
+		}
+		if config.DryRun {
+			log.Warnf("Dry run: tag %s does not exist in paperless-ngx and would be created", tag)
+			continue
+		}
+		tagID, err := app.PaperlessClient.CreateTag(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("error creating tag %s: %w", tag, err)
+		}
+		available[tag] = tagID
+		log.Infof("Created tag %s in paperless-ngx", tag)
+	}
+	return nil
+}
 
-	// Check for blacklisted tags
-	for _, tag := range document.Tags {
-		for _, blacklistedTag := range tagBlackList {
-			if tag == blacklistedTag {
-				log.Debugf("Document with id: '%d' has a blacklisted tag: '%s'. Skipping auto-tagging.", document.ID, tag)
-				return 0, nil
+// runWorker processes the documents dispatched to the pipeline until its job channel is closed
+func (app *App) runWorker(ctx context.Context, p *pipeline) {
+	for job := range p.jobs {
+		processedCount, err := app.processDocument(ctx, p, job.document)
+		var unavailable *llmUnavailableError
+		var applied *paperless_service.UpdateAppliedError
+		if errors.As(err, &applied) {
+			// The suggestion was applied and the trigger tag removed, processing the document again would repeat it
+			log.Errorf("Error finishing document %d: %v", job.document.ID, err)
+			err = nil
+		}
+		if err != nil && ctx.Err() != nil {
+			// Cancelled by the shutdown, the document keeps its trigger tag and is processed again after a restart
+			log.Warnf("Processing of document %d was cancelled by the shutdown: %v", job.document.ID, err)
+		} else if errors.As(err, &unavailable) {
+			// The document itself is fine, so waiting for a backend does not count as failed attempt
+			log.Warnf("Postponing document %d: %v", job.document.ID, err)
+			app.failures.postpone(job.document.ID, unavailable.retryAt)
+		} else if err != nil {
+			app.handleDocumentFailure(ctx, p, job.document, err)
+		} else {
+			app.failures.reset(job.document.ID)
+			if config.DryRun {
+				// The trigger tag is not removed in dry-run mode, so the document must not be picked up again
+				app.dryRunDone.tryAdd(job.document.ID)
 			}
 		}
+		app.inFlight.remove(job.document.ID)
+		job.done(processedCount)
 	}
+}
 
-	suggestion, err := suggestionFunc(ctx, document)
+// processDocument generates a suggestion for a single document and applies it or queues it for a review
+func (app *App) processDocument(ctx context.Context, p *pipeline, document paperless_model.Document) (int, error) {
+	// Generate suggestion for the document by running the steps of the pipeline
+	suggestion, err := app.runPipeline(ctx, p, document)
 	if err != nil {
 		return 0, fmt.Errorf("error generating suggestion: %w", err)
 	}
 
-	*suggestion.Tags = paperless_service.RemoveTagFromList(*suggestion.Tags, tagName)
+	// Suggestions for a manual review are kept complete, otherwise fields the LLM is not confident about are left for a human,
+	// who finds the document by the needs-review tag
+	reviewRequired := requiresReview(document, *suggestion)
+	var proposals []uncertainProposal
+	if !reviewRequired {
+		proposals = withholdUncertainFields(p.definition, suggestion, document)
+	}
+	if len(proposals) > 0 {
+		*suggestion.Tags = append(paperless_service.RemoveTagFromList(*suggestion.Tags, config.NeedsReviewTag), config.NeedsReviewTag)
+		log.Infof("Document %d needs a review, %d fields are below the confidence threshold", document.ID, len(proposals))
+	}
+
+	*suggestion.Tags = paperless_service.RemoveTagFromList(*suggestion.Tags, p.tagName)
+	if p.definition.CompletionTag != "" {
+		*suggestion.Tags = append(paperless_service.RemoveTagFromList(*suggestion.Tags, p.definition.CompletionTag), p.definition.CompletionTag)
+	}
+
+	// Keep the suggestion for a manual review instead of applying it
+	if reviewRequired {
+		if err := app.queueForReview(ctx, *suggestion, p.tagName, p.customFieldName); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
 
 	// Update document with suggestion
-	err = app.PaperlessClient.UpdateDocument(ctx, *suggestion, customFieldName)
+	err = app.PaperlessClient.UpdateDocument(ctx, *suggestion, p.customFieldName)
+	var applied *paperless_service.UpdateAppliedError
+	if errors.As(err, &applied) {
+		return 1, err
+	}
 	if err != nil {
 		return 0, fmt.Errorf("error updating documents: %w", err)
 	}
 
+	if len(proposals) > 0 {
+		if err := app.PaperlessClient.AddNote(ctx, document.ID, uncertainProposalsNote(proposals)); err != nil {
+			return 1, &paperless_service.UpdateAppliedError{DocumentID: document.ID, Err: fmt.Errorf("adding the uncertain fields failed: %w", err)}
+		}
+	}
+
 	return 1, nil
 }
 
 // createLLM creates the appropriate LlmClient client based on the provider
-func createLLM() (llms.Model, error) {
-	switch strings.ToLower(config.LlmProvider) {
+func createLLM(provider string, model string) (llms.Model, error) {
+	switch strings.ToLower(provider) {
+	case "openai", "openai-compatible", "azure":
+		options, err := openAIOptions(strings.ToLower(provider), model)
+		if err != nil {
+			return nil, err
+		}
+		return openai.New(options...)
+	case "ollama":
+		return ollama.New(ollamaOptions(model)...)
+	case "anthropic":
+		// Anthropic has no JSON mode, answers are only checked by the validation of suggestions
+		settings := config.ProviderSettings(provider)
+		options := []anthropic.Option{
+			anthropic.WithModel(model),
+			anthropic.WithToken(settings.APIKey),
+			anthropic.WithHTTPClient(&http.Client{Transport: llmTransport(settings, "", nil)}),
+		}
+		if settings.BaseURL != "" {
+			options = append(options, anthropic.WithBaseURL(settings.BaseURL))
+		}
+		return anthropic.New(options...)
+	default:
+		return nil, fmt.Errorf("unsupported LlmClient provider: %s", provider)
+	}
+}
+
+// openAIOptions returns the client options of the providers using the OpenAI API: openai, openai-compatible and azure
+func openAIOptions(provider string, model string) ([]openai.Option, error) {
+	settings := config.ProviderSettings(provider)
+	switch provider {
 	case "openai":
-		if config.OpenaiAPIKey == "" {
+		if settings.APIKey == "" {
 			return nil, fmt.Errorf("OpenAI API key is not set")
 		}
-		return openai.New(
-			openai.WithModel(config.LlmModel),
-			openai.WithToken(config.OpenaiAPIKey),
-		)
-	case "ollama":
-		host := os.Getenv("OLLAMA_HOST")
-		if host == "" {
-			host = "http://127.0.0.1:11434"
-		}
-		return ollama.New(
-			ollama.WithModel(config.LlmModel),
-			ollama.WithServerURL(host),
-		)
+		options := []openai.Option{
+			openai.WithModel(model),
+			openai.WithToken(settings.APIKey),
+			openai.WithHTTPClient(&http.Client{Transport: llmTransport(settings, settings.APIKeyHeader, newOpenAIStructuredOutputTransport)}),
+		}
+		if settings.BaseURL != "" {
+			options = append(options, openai.WithBaseURL(settings.BaseURL))
+		}
+		return options, nil
+	case "openai-compatible":
+		// langchaingo requires a token, servers without authentication never see the placeholder since headerTransport removes it
+		token := settings.APIKey
+		if token == "" {
+			token = "none"
+		}
+		return []openai.Option{
+			openai.WithModel(model),
+			openai.WithToken(token),
+			openai.WithBaseURL(settings.BaseURL),
+			openai.WithHTTPClient(&http.Client{Transport: llmTransport(settings, settings.APIKeyHeader, newOpenAIStructuredOutputTransport)}),
+		}, nil
 	default:
-		return nil, fmt.Errorf("unsupported LlmClient provider: %s", config.LlmProvider)
+		// Azure OpenAI addresses models by the name of their deployment
+		transport := llmTransport(settings, "", newOpenAIStructuredOutputTransport)
+		apiType, token := openai.APITypeAzure, settings.APIKey
+		if config.AzureUsesClientCredentials() {
+			// The token is replaced by the one of the service principal on every request
+			apiType, token = openai.APITypeAzureAD, "none"
+			transport = newAzureADTransport(transport)
+		}
+		return []openai.Option{
+			openai.WithModel(config.AzureDeployment(model)),
+			openai.WithToken(token),
+			openai.WithBaseURL(settings.BaseURL),
+			openai.WithAPIType(apiType),
+			openai.WithAPIVersion(config.AzureOpenaiAPIVersion),
+			openai.WithHTTPClient(&http.Client{Transport: transport}),
+		}, nil
+	}
+}
+
+// ollamaOptions returns the client options of the ollama provider
+func ollamaOptions(model string) []ollama.Option {
+	settings := config.ProviderSettings("ollama")
+	options := []ollama.Option{
+		ollama.WithModel(model),
+		ollama.WithServerURL(settings.BaseURL),
+		ollama.WithHTTPClient(&http.Client{Transport: llmTransport(settings, "", newOllamaStructuredOutputTransport)}),
+	}
+	if settings.NumCtx > 0 {
+		options = append(options, ollama.WithRunnerNumCtx(settings.NumCtx))
+	}
+	if settings.KeepAlive != "" {
+		options = append(options, ollama.WithKeepAlive(settings.KeepAlive))
 	}
+	return options
 }
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"paperless-gpt/internal/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// azureCognitiveServicesScope is the scope of tokens accepted by Azure OpenAI
+	azureCognitiveServicesScope = "https://cognitiveservices.azure.com/.default"
+	// azureTokenRefreshMargin is how long before its expiry a token is replaced
+	azureTokenRefreshMargin = 5 * time.Minute
+)
+
+// azureADTransport authenticates requests to Azure OpenAI with a Microsoft Entra ID token of a service principal.
+// Tokens are fetched with the client credentials flow and replaced before they expire.
+type azureADTransport struct {
+	base         http.RoundTripper
+	tokenURL     string
+	clientID     string
+	clientSecret string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// newAzureADTransport returns a transport authenticating with AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET
+func newAzureADTransport(base http.RoundTripper) http.RoundTripper {
+	return &azureADTransport{
+		base:         base,
+		tokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimRight(config.AzureAuthorityHost, "/"), url.PathEscape(config.AzureTenantID)),
+		clientID:     config.AzureClientID,
+		clientSecret: config.AzureClientSecret,
+	}
+}
+
+func (transport *azureADTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := transport.accessToken(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	// The request is cloned, since a RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return transport.base.RoundTrip(req)
+}
+
+// accessToken returns the current token, fetching a new one if it expires soon
+func (transport *azureADTransport) accessToken(ctx context.Context) (string, error) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	if transport.token != "" && time.Now().Before(transport.expiresAt.Add(-azureTokenRefreshMargin)) {
+		return transport.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {transport.clientID},
+		"client_secret": {transport.clientSecret},
+		"scope":         {azureCognitiveServicesScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, transport.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error creating Azure token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting Azure token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading Azure token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error requesting Azure token: %d, %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil || tokenResponse.AccessToken == "" {
+		return "", fmt.Errorf("invalid Azure token response: %s", string(body))
+	}
+
+	transport.token = tokenResponse.AccessToken
+	transport.expiresAt = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	log.Debugf("Fetched Azure token, valid until %s", transport.expiresAt.Format(time.RFC3339))
+	return transport.token, nil
+}
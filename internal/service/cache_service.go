@@ -0,0 +1,204 @@
+package service
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"paperless-gpt/internal/cache"
+	"paperless-gpt/internal/config"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// cacheNames are the caches in CACHE_DIR, each in a subdirectory of its name
+var cacheNames = []string{"ocr", "llm"}
+
+// cachedAnswer is an answer of the LlmClient kept in the cache
+type cachedAnswer struct {
+	Answer string `json:"answer"`
+	// Model is the model which gave the answer
+	Model string `json:"model"`
+}
+
+// openCache opens the cache of the given name in CACHE_DIR. CACHE_MAX_MB=0 disables caching, then nil is returned.
+func openCache(name string) (*cache.Store, error) {
+	if config.CacheMaxMB == 0 {
+		return nil, nil
+	}
+	return cache.Open(filepath.Join(config.CacheDir, name), config.CacheTTL, int64(config.CacheMaxMB)<<20)
+}
+
+// llmCacheKey derives the key of the answer to a prompt from everything which changes the answer: the provider and
+// model of the first backend of the pipeline, its generation options and the prompt
+func llmCacheKey(p *pipeline, prompt string) string {
+	if model, ok := p.llm.(*fallbackModel); ok && len(model.backends) > 0 {
+		return cache.Key("llm", model.backends[0].name, model.backends[0].generationKey, prompt)
+	}
+	return cache.Key("llm", p.modelName, prompt)
+}
+
+// cacheable reports whether a response of the LlmClient of a pipeline may be cached under llmCacheKey. Answers of
+// fallbacks are not cached, since the key names the first backend, whose answer would differ.
+func cacheable(p *pipeline, response *llms.ContentResponse) bool {
+	model, ok := p.llm.(*fallbackModel)
+	if !ok || len(model.backends) == 0 {
+		return true
+	}
+	backend, _ := response.Choices[0].GenerationInfo[generationInfoBackend].(string)
+	return backend == model.backends[0].name
+}
+
+// cachedLlmAnswer returns the cached answer for key, if caching is enabled and the answer is cached
+func (app *App) cachedLlmAnswer(key string) (cachedAnswer, bool) {
+	var answer cachedAnswer
+	if app.llmCache == nil || !app.llmCache.Get(key, &answer) {
+		return answer, false
+	}
+	return answer, true
+}
+
+// cacheLlmAnswer stores an answer in the cache, if caching is enabled
+func (app *App) cacheLlmAnswer(key string, description string, answer cachedAnswer) {
+	if app.llmCache == nil {
+		return
+	}
+	if err := app.llmCache.Put(key, description, answer); err != nil {
+		// The answer is still valid, it just has to be generated again next time
+		log.Warnf("Failed to cache answer of %s: %v", answer.Model, err)
+	}
+}
+
+// Cache inspects and purges the caches in CACHE_DIR, called by the "cache" command
+func Cache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: cache stats|list|purge [flags]")
+	}
+
+	flags := flag.NewFlagSet("cache "+args[0], flag.ContinueOnError)
+	name := flags.String("cache", "", "only the ocr or llm cache, all caches if empty")
+	expired := flags.Bool("expired", false, "purge only entries older than CACHE_TTL and the least recently used entries above CACHE_MAX_MB")
+	olderThan := flags.Duration("older-than", 0, "purge only entries created before this duration, e.g. 168h")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	names := cacheNames
+	if *name != "" {
+		if !containsString(cacheNames, *name) {
+			return fmt.Errorf("unknown cache %q, must be one of %s", *name, strings.Join(cacheNames, ", "))
+		}
+		names = []string{*name}
+	}
+
+	// The caches are opened even if caching is disabled, so they can still be inspected and purged
+	stores := make(map[string]*cache.Store, len(names))
+	for _, cacheName := range names {
+		store, err := cache.Open(filepath.Join(config.CacheDir, cacheName), config.CacheTTL, int64(config.CacheMaxMB)<<20)
+		if err != nil {
+			return err
+		}
+		stores[cacheName] = store
+	}
+
+	switch args[0] {
+	case "stats":
+		return cacheStats(names, stores)
+	case "list":
+		return listCache(names, stores)
+	case "purge":
+		return purgeCache(names, stores, *expired, *olderThan)
+	default:
+		return fmt.Errorf("unknown cache command %q, must be stats, list or purge", args[0])
+	}
+}
+
+// cacheStats prints the number of entries and size of every cache
+func cacheStats(names []string, stores map[string]*cache.Store) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CACHE\tENTRIES\tSIZE\tEXPIRED\tOLDEST\tDIRECTORY")
+	for _, name := range names {
+		entries, err := stores[name].Entries()
+		if err != nil {
+			return err
+		}
+
+		var size int64
+		expired := 0
+		var oldest time.Time
+		for _, entry := range entries {
+			size += entry.Size
+			if config.CacheTTL > 0 && time.Since(entry.Created) > config.CacheTTL {
+				expired++
+			}
+			if oldest.IsZero() || entry.Created.Before(oldest) {
+				oldest = entry.Created
+			}
+		}
+		oldestCreated := "-"
+		if !oldest.IsZero() {
+			oldestCreated = oldest.Format(time.RFC3339)
+		}
+		fmt.Fprintf(writer, "%s\t%d\t%s\t%d\t%s\t%s\n", name, len(entries), formatBytes(size), expired, oldestCreated, stores[name].Dir())
+	}
+	return writer.Flush()
+}
+
+// listCache prints every entry, the most recently used first
+func listCache(names []string, stores map[string]*cache.Store) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CACHE\tKEY\tSIZE\tCREATED\tLAST USED\tDESCRIPTION")
+	for _, name := range names {
+		entries, err := stores[name].Entries()
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		})
+		for _, entry := range entries {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\n", name, entry.Key[:min(len(entry.Key), 12)], formatBytes(entry.Size),
+				entry.Created.Format(time.RFC3339), entry.LastUsed.Format(time.RFC3339), entry.Description)
+		}
+	}
+	return writer.Flush()
+}
+
+// purgeCache deletes all entries, only the expired ones, or the ones older than olderThan
+func purgeCache(names []string, stores map[string]*cache.Store, expired bool, olderThan time.Duration) error {
+	for _, name := range names {
+		var removed int
+		var err error
+		switch {
+		case expired:
+			removed, err = stores[name].Prune()
+		case olderThan > 0:
+			removed, _, err = stores[name].Remove(func(entry cache.Entry) bool {
+				return time.Since(entry.Created) > olderThan
+			})
+		default:
+			removed, _, err = stores[name].Remove(func(cache.Entry) bool { return true })
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d entries from the %s cache\n", removed, name)
+	}
+	return nil
+}
+
+// formatBytes formats a size in bytes for humans
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"math"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Weights of the signals which are added up to the score of a candidate
+	lexicalWeight   = 1.0
+	usageWeight     = 0.3
+	neighbourWeight = 0.5
+	// keepWeight makes sure the current metadata of a document is always offered
+	keepWeight = 10.0
+	// minTermRunes ignores short words like "AG" or "de", which would match nearly every document
+	minTermRunes = 3
+	// minPrefixRunes is the length of a common prefix for words to match, e.g. "Rechnung" and "Rechnungen"
+	minPrefixRunes = 5
+	// maxPrefixRunes limits the prefixes collected per word, longer words only match from their beginning
+	maxPrefixRunes = 24
+	// candidateNeighbours is the number of similar documents whose metadata is taken into account
+	candidateNeighbours = 10
+)
+
+// unknownAnswers are the normalized answers of models which did not find a matching value
+var unknownAnswers = []string{"unknown", "unbekannt", "none", "keine", "keiner", "na"}
+
+// candidates are the names of tags, correspondents and document types offered to the LLM
+type candidates struct {
+	tags           []string
+	correspondents []string
+	documentTypes  []string
+}
+
+// selectCandidates narrows the available names to the CANDIDATE_TAGS, CANDIDATE_CORRESPONDENTS and CANDIDATE_DOCUMENT_TYPES
+// most likely ones for the document, so the prompt stays small for archives with thousands of correspondents.
+// Names are scored by the words they share with the document, how many documents they are assigned to and, with
+// the embedding index, how often the most similar documents use them. The current metadata of the document is always kept.
+func (app *App) selectCandidates(ctx context.Context, document paperless_model.Document, available candidates) candidates {
+	if !exceedsLimit(available.tags, config.CandidateTags) && !exceedsLimit(available.correspondents, config.CandidateCorrespondents) && !exceedsLimit(available.documentTypes, config.CandidateDocumentTypes) {
+		return available
+	}
+
+	counts, err := app.PaperlessClient.GetDocumentCounts(ctx)
+	if err != nil {
+		// The content alone still gives a useful selection
+		log.Warnf("Failed to fetch document counts for the candidates of document %d: %v", document.ID, err)
+	}
+	neighbours := app.neighbourUsage(ctx, document)
+	terms := newTermSet(document.Title + "\n" + document.Content)
+
+	var currentCorrespondent, currentDocumentType []string
+	if document.Correspondent != "" {
+		currentCorrespondent = []string{document.Correspondent}
+	}
+	if document.DocumentType != "" {
+		currentDocumentType = []string{document.DocumentType}
+	}
+
+	selected := candidates{
+		tags:           rankCandidates(available.tags, config.CandidateTags, terms, counts.Tags, neighbours.tags, document.Tags),
+		correspondents: rankCandidates(available.correspondents, config.CandidateCorrespondents, terms, counts.Correspondents, neighbours.correspondents, currentCorrespondent),
+		documentTypes:  rankCandidates(available.documentTypes, config.CandidateDocumentTypes, terms, counts.DocumentTypes, neighbours.documentTypes, currentDocumentType),
+	}
+	log.Debugf("Candidates for document %d: tags %v, correspondents %v, document types %v", document.ID, selected.tags, selected.correspondents, selected.documentTypes)
+	return selected
+}
+
+// widenUnknown offers the full list of every field the model answered with "Unknown" for, or with no tags at all, if
+// the list was narrowed before. It reports whether a list was widened.
+func (selected candidates) widenUnknown(suggestion *paperless_model.DocumentSuggestion, available candidates) (candidates, bool) {
+	widened := false
+	if len(selected.correspondents) < len(available.correspondents) && isUnknownAnswer(suggestion.Correspondent) {
+		selected.correspondents = available.correspondents
+		widened = true
+	}
+	if len(selected.documentTypes) < len(available.documentTypes) && isUnknownAnswer(suggestion.DocumentType) {
+		selected.documentTypes = available.documentTypes
+		widened = true
+	}
+	if len(selected.tags) < len(available.tags) && (suggestion.Tags == nil || len(*suggestion.Tags) == 0) {
+		selected.tags = available.tags
+		widened = true
+	}
+	return selected, widened
+}
+
+// isUnknownAnswer reports whether a suggested value is missing or a placeholder like "Unknown"
+func isUnknownAnswer(value *string) bool {
+	if value == nil {
+		return true
+	}
+	normalized := normalizeName(*value)
+	return normalized == "" || containsString(unknownAnswers, normalized)
+}
+
+// exceedsLimit reports whether a list is longer than a limit, where 0 means no limit
+func exceedsLimit(names []string, limit int) bool {
+	return limit > 0 && len(names) > limit
+}
+
+// rankCandidates returns the limit names with the highest score, sorted by name. A limit of 0 keeps all names.
+func rankCandidates(names []string, limit int, terms termSet, usage map[string]int, neighbours map[string]float64, keep []string) []string {
+	if !exceedsLimit(names, limit) {
+		return names
+	}
+
+	maxUsage := 0
+	for _, count := range usage {
+		maxUsage = max(maxUsage, count)
+	}
+
+	scores := make(map[string]float64, len(names))
+	for _, name := range names {
+		score := lexicalWeight*terms.overlap(name) + neighbourWeight*neighbours[name]
+		if maxUsage > 0 {
+			// Frequently used names are more likely, but a few large ones must not crowd out everything else
+			score += usageWeight * math.Log1p(float64(usage[name])) / math.Log1p(float64(maxUsage))
+		}
+		if containsString(keep, name) {
+			score += keepWeight
+		}
+		scores[name] = score
+	}
+
+	ranked := append([]string{}, names...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if scores[ranked[i]] == scores[ranked[j]] {
+			return ranked[i] < ranked[j]
+		}
+		return scores[ranked[i]] > scores[ranked[j]]
+	})
+	// Sorted by name again, so equal selections render equal prompts
+	return sortStrings(ranked[:limit])
+}
+
+// neighbourUsage is the share of the most similar filed documents using each name
+type neighbourUsage struct {
+	tags           map[string]float64
+	correspondents map[string]float64
+	documentTypes  map[string]float64
+}
+
+// neighbourUsage looks up the most similar filed documents in the embedding index. Without the index it is empty.
+func (app *App) neighbourUsage(ctx context.Context, document paperless_model.Document) neighbourUsage {
+	usage := neighbourUsage{tags: map[string]float64{}, correspondents: map[string]float64{}, documentTypes: map[string]float64{}}
+	if app.embeddingIndex == nil {
+		return usage
+	}
+
+	similarDocuments, err := app.similarDocuments(ctx, document, candidateNeighbours)
+	if err != nil {
+		log.Warnf("Failed to fetch documents similar to document %d: %v", document.ID, err)
+		return usage
+	}
+
+	unfiledTags := app.unfiledTags()
+	var filedDocuments []paperless_model.Document
+	for _, similarDocument := range similarDocuments {
+		if similarDocument.ID != document.ID && !containsAny(similarDocument.Tags, unfiledTags) {
+			filedDocuments = append(filedDocuments, similarDocument)
+		}
+	}
+	share := 1 / float64(max(len(filedDocuments), 1))
+	for _, filedDocument := range filedDocuments {
+		for _, tag := range filedDocument.Tags {
+			usage.tags[tag] += share
+		}
+		usage.correspondents[filedDocument.Correspondent] += share
+		usage.documentTypes[filedDocument.DocumentType] += share
+	}
+	return usage
+}
+
+// termSet holds the words of a text, and their prefixes to match inflected forms
+type termSet struct {
+	words    map[string]bool
+	prefixes map[string]bool
+}
+
+// newTermSet collects the lowercased words of a text
+func newTermSet(text string) termSet {
+	terms := termSet{words: map[string]bool{}, prefixes: map[string]bool{}}
+	for _, word := range splitWords(text) {
+		terms.words[word] = true
+		runes := []rune(word)
+		for length := minPrefixRunes; length < len(runes) && length <= maxPrefixRunes; length++ {
+			terms.prefixes[string(runes[:length])] = true
+		}
+	}
+	return terms
+}
+
+// contains reports whether the text contains the word, or a word sharing a prefix of at least minPrefixRunes with it
+func (terms termSet) contains(word string) bool {
+	if terms.words[word] {
+		return true
+	}
+	runes := []rune(word)
+	if len(runes) < minPrefixRunes {
+		return false
+	}
+	if terms.prefixes[word] {
+		return true
+	}
+	for length := minPrefixRunes; length < len(runes); length++ {
+		if terms.words[string(runes[:length])] {
+			return true
+		}
+	}
+	return false
+}
+
+// overlap returns the share of the words of a name which occur in the text. Short words are only considered
+// for names which consist of short words only.
+func (terms termSet) overlap(name string) float64 {
+	words := splitWords(name)
+	var significant []string
+	for _, word := range words {
+		if len([]rune(word)) >= minTermRunes {
+			significant = append(significant, word)
+		}
+	}
+	if len(significant) == 0 {
+		significant = words
+	}
+	if len(significant) == 0 {
+		return 0
+	}
+
+	matched := 0
+	for _, word := range significant {
+		if terms.contains(word) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(significant))
+}
+
+// splitWords splits a text into lowercased words of letters and digits
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"paperless-gpt/paperless/paperless_model"
+	"reflect"
+	"testing"
+)
+
+func TestTermSetOverlap(t *testing.T) {
+	terms := newTermSet("Stadtwerke München GmbH\nRechnung Nr. 12345 für Strom, Abschlag März")
+
+	tests := []struct {
+		name    string
+		overlap float64
+	}{
+		{"Stadtwerke München", 1},
+		{"stadtwerke", 1},
+		{"Rechnungen", 1},
+		{"Strom & Gas", 0.5},
+		{"Stadtwerke Augsburg", 0.5},
+		{"Versicherung", 0},
+		{"Rech", 0},
+		{"Nr", 1},
+		{"AG", 0},
+		{"", 0},
+		{"12345", 1},
+	}
+
+	for _, test := range tests {
+		if overlap := terms.overlap(test.name); overlap != test.overlap {
+			t.Errorf("overlap(%q) = %g, want %g", test.name, overlap, test.overlap)
+		}
+	}
+}
+
+func TestTermSetContains(t *testing.T) {
+	terms := newTermSet("Rechnungen Versicherung Kfz")
+
+	tests := []struct {
+		word     string
+		contains bool
+	}{
+		{"rechnungen", true},
+		{"rechnung", true},
+		{"versicherungen", true},
+		{"kfz", true},
+		{"kfzs", false},
+		{"rechn", true},
+		{"rech", false},
+		{"vertrag", false},
+	}
+
+	for _, test := range tests {
+		if contains := terms.contains(test.word); contains != test.contains {
+			t.Errorf("contains(%q) = %t, want %t", test.word, contains, test.contains)
+		}
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	names := []string{"Amazon", "Bank", "Finanzamt", "Stadtwerke", "Telekom", "Versicherung"}
+	terms := newTermSet("Stadtwerke Musterstadt, Abrechnung Strom")
+
+	tests := []struct {
+		name       string
+		limit      int
+		usage      map[string]int
+		neighbours map[string]float64
+		keep       []string
+		ranked     []string
+	}{
+		{
+			name:   "no limit",
+			limit:  0,
+			ranked: names,
+		},
+		{
+			name:   "within the limit",
+			limit:  len(names),
+			ranked: names,
+		},
+		{
+			name:   "words of the document",
+			limit:  1,
+			ranked: []string{"Stadtwerke"},
+		},
+		{
+			name:   "usage breaks ties",
+			limit:  2,
+			usage:  map[string]int{"Telekom": 500, "Bank": 3},
+			ranked: []string{"Stadtwerke", "Telekom"},
+		},
+		{
+			name:       "similar documents",
+			limit:      2,
+			neighbours: map[string]float64{"Finanzamt": 0.8},
+			ranked:     []string{"Finanzamt", "Stadtwerke"},
+		},
+		{
+			name:   "current value is kept",
+			limit:  2,
+			keep:   []string{"Versicherung"},
+			ranked: []string{"Stadtwerke", "Versicherung"},
+		},
+		{
+			name:   "ties are broken by name",
+			limit:  2,
+			ranked: []string{"Amazon", "Stadtwerke"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranked := rankCandidates(names, test.limit, terms, test.usage, test.neighbours, test.keep)
+			if !reflect.DeepEqual(ranked, test.ranked) {
+				t.Errorf("rankCandidates() = %q, want %q", ranked, test.ranked)
+			}
+		})
+	}
+}
+
+func TestWidenUnknown(t *testing.T) {
+	available := candidates{
+		tags:           []string{"Rechnung", "Steuer", "Versicherung"},
+		correspondents: []string{"Amazon", "Stadtwerke", "Telekom"},
+		documentTypes:  []string{"Brief", "Rechnung", "Vertrag"},
+	}
+	selected := candidates{tags: []string{"Rechnung"}, correspondents: []string{"Amazon"}, documentTypes: []string{"Rechnung"}}
+
+	tests := []struct {
+		name       string
+		suggestion paperless_model.DocumentSuggestion
+		widened    candidates
+	}{
+		{
+			name:       "known answers",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{"Rechnung"}, Correspondent: stringPointer("Amazon"), DocumentType: stringPointer("Rechnung")},
+			widened:    selected,
+		},
+		{
+			name:       "unknown answers",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{}, Correspondent: stringPointer("Unbekannt"), DocumentType: stringPointer("N/A")},
+			widened:    available,
+		},
+		{
+			name:       "missing correspondent",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{"Rechnung"}, DocumentType: stringPointer("Rechnung")},
+			widened:    candidates{tags: selected.tags, correspondents: available.correspondents, documentTypes: selected.documentTypes},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			widened, changed := selected.widenUnknown(&test.suggestion, available)
+			if !reflect.DeepEqual(widened, test.widened) {
+				t.Errorf("widenUnknown() = %+v, want %+v", widened, test.widened)
+			}
+			if wantChanged := !reflect.DeepEqual(test.widened, selected); changed != wantChanged {
+				t.Errorf("widenUnknown() reported %t, want %t", changed, wantChanged)
+			}
+		})
+	}
+}
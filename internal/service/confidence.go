@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+)
+
+// uncertainProposal is a suggested value which was not applied, because the confidence of the LLM is below CONFIDENCE_THRESHOLD
+type uncertainProposal struct {
+	field      string
+	value      string
+	confidence float64
+}
+
+// withholdUncertainFields leaves every field of the suggestion unchanged whose confidence is below CONFIDENCE_THRESHOLD
+// and returns the withheld proposals. Only fields the pipeline writes and which would change something are considered.
+func withholdUncertainFields(definition config.PipelineDefinition, suggestion *paperless_model.DocumentSuggestion, document paperless_model.Document) []uncertainProposal {
+	if config.ConfidenceThreshold == nil || suggestion.Confidence == nil {
+		return nil
+	}
+	threshold := *config.ConfidenceThreshold
+	confidence := suggestion.Confidence
+
+	var proposals []uncertainProposal
+	withhold := func(field string, value **string, fieldConfidence float64) {
+		if !definition.Writes(field) || *value == nil || **value == "" || fieldConfidence >= threshold {
+			return
+		}
+		proposals = append(proposals, uncertainProposal{field: field, value: **value, confidence: fieldConfidence})
+		*value = nil
+	}
+	withhold(config.FieldTitle, &suggestion.Title, confidence.Title)
+	withhold(config.FieldCorrespondent, &suggestion.Correspondent, confidence.Correspondent)
+	withhold(config.FieldDocumentType, &suggestion.DocumentType, confidence.DocumentType)
+	withhold(config.FieldCreatedDate, &suggestion.Date, confidence.CreatedDate)
+
+	if definition.Writes(config.FieldTags) && suggestion.Tags != nil && confidence.Tags < threshold {
+		var newTags []string
+		for _, tag := range *suggestion.Tags {
+			if !containsString(document.Tags, tag) {
+				newTags = append(newTags, tag)
+			}
+		}
+		if len(newTags) > 0 {
+			proposals = append(proposals, uncertainProposal{field: config.FieldTags, value: strings.Join(newTags, ", "), confidence: confidence.Tags})
+			existingTags := append([]string{}, document.Tags...)
+			suggestion.Tags = &existingTags
+		}
+	}
+
+	return proposals
+}
+
+// uncertainProposalsNote lists the withheld proposals for the note added to the document
+func uncertainProposalsNote(proposals []uncertainProposal) string {
+	var note strings.Builder
+	note.WriteString(fmt.Sprintf("paperless-gpt left these fields unchanged, since its confidence is below %.2f:", *config.ConfidenceThreshold))
+	for _, proposal := range proposals {
+		note.WriteString(fmt.Sprintf("\n- %s: %s (confidence %.2f)", proposal.field, proposal.value, proposal.confidence))
+	}
+	return note.String()
+}
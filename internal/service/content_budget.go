@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"strings"
+	"text/template"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	// defaultContextWindow is assumed for models of unknown context window size
+	defaultContextWindow = 8192
+	// defaultOllamaContextWindow is the context window Ollama uses if LLM_NUM_CTX is not set
+	defaultOllamaContextWindow = 4096
+	// defaultResponseTokens are kept free for the answer if LLM_MAX_TOKENS is not set
+	defaultResponseTokens = 1024
+	// minContentTokens are left for the content even if the prompt alone nearly fills the context window
+	minContentTokens = 256
+	// maxCondenseRounds limits how often notes on chunks are condensed again, if they still do not fit
+	maxCondenseRounds = 3
+)
+
+// knownContextWindows are the context window sizes of common models by prefix of their name. The first matching prefix wins.
+var knownContextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+}
+
+// contextWindow returns the number of tokens a model accepts for prompt and answer together
+func contextWindow(backend config.LlmBackend) int {
+	if config.LlmContextWindow > 0 {
+		return config.LlmContextWindow
+	}
+	if backend.Provider == "ollama" {
+		// Ollama silently drops the beginning of prompts which exceed its context window
+		if numCtx := config.ProviderSettings(backend.Provider).NumCtx; numCtx > 0 {
+			return numCtx
+		}
+		return defaultOllamaContextWindow
+	}
+
+	modelName := strings.ToLower(backend.Model)
+	for _, known := range knownContextWindows {
+		if strings.HasPrefix(modelName, known.prefix) {
+			return known.tokens
+		}
+	}
+	return defaultContextWindow
+}
+
+// responseTokens returns the number of tokens kept free for the answer of a model
+func responseTokens(backend config.LlmBackend) int {
+	if maxTokens := config.ProviderSettings(backend.Provider).MaxTokens; maxTokens > 0 {
+		return maxTokens
+	}
+	return defaultResponseTokens
+}
+
+// contentBudget returns the number of tokens left for the content of a document in a prompt,
+// given the number of tokens of the prompt without the content
+func (p *pipeline) contentBudget(promptTokens int) int {
+	budget := p.contextWindow - p.responseTokens - promptTokens
+	if budget < minContentTokens {
+		log.Warnf("The prompt of pipeline %s takes %d of %d tokens of the context window, increase LLM_CONTEXT_WINDOW", p.name, promptTokens, p.contextWindow)
+		return minContentTokens
+	}
+	return budget
+}
+
+// fitContent returns content of at most maxTokens tokens. Longer content is split into chunks, which the LlmClient
+// of the pipeline condenses to notes one by one. The notes of all chunks replace the content, and are condensed
+// again if they are still too long.
+func (app *App) fitContent(ctx context.Context, p *pipeline, documentID int, content string, maxTokens int, languageName string) (string, error) {
+	contentTokens := countTokens(content)
+	if contentTokens <= maxTokens {
+		return content, nil
+	}
+
+	chunkPromptTokens, err := countPromptTokens(config.ChunkPrompt.Current().Template, chunkPromptData("", 0, 0, languageName))
+	if err != nil {
+		return "", err
+	}
+	chunkTokens := p.contentBudget(chunkPromptTokens)
+
+	for round := 1; contentTokens > maxTokens; round++ {
+		if round > maxCondenseRounds {
+			log.Warnf("Notes on document %d still have %d tokens after %d rounds, truncating them to %d tokens", documentID, contentTokens, maxCondenseRounds, maxTokens)
+			return splitText(content, maxTokens)[0], nil
+		}
+
+		chunks := splitText(content, chunkTokens)
+		if len(chunks) > config.LlmMaxChunks {
+			log.Warnf("Document %d has %d chunks, only the first %d are read (LLM_MAX_CHUNKS)", documentID, len(chunks), config.LlmMaxChunks)
+			chunks = chunks[:config.LlmMaxChunks]
+		}
+		log.Infof("Document %d has %d tokens, more than the %d tokens left in the prompt, condensing it in %d chunks", documentID, contentTokens, maxTokens, len(chunks))
+
+		notes := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			chunkNotes, err := app.condenseChunk(ctx, p, chunk, i+1, len(chunks), languageName)
+			if err != nil {
+				return "", fmt.Errorf("error condensing part %d of document %d: %w", i+1, documentID, err)
+			}
+			notes = append(notes, fmt.Sprintf("Notes on part %d of %d:\n%s", i+1, len(chunks), chunkNotes))
+		}
+
+		content = strings.Join(notes, "\n\n")
+		contentTokens = countTokens(content)
+		log.Debugf("Notes on document %d after round %d have %d tokens: %s", documentID, round, contentTokens, content)
+	}
+	return content, nil
+}
+
+// condenseChunk asks the LlmClient of the pipeline for notes on a single chunk of a document
+func (app *App) condenseChunk(ctx context.Context, p *pipeline, chunk string, chunkNumber int, chunkCount int, languageName string) (string, error) {
+	var promptBuffer bytes.Buffer
+	if err := config.ChunkPrompt.Current().Template.Execute(&promptBuffer, chunkPromptData(chunk, chunkNumber, chunkCount, languageName)); err != nil {
+		return "", fmt.Errorf("error executing chunk template: %v", err)
+	}
+
+	// Notes on earlier chunks are kept, so a document interrupted while it was condensed continues where it stopped
+	prompt := promptBuffer.String()
+	cacheKey := llmCacheKey(p, prompt)
+	if cached, found := app.cachedLlmAnswer(cacheKey); found {
+		return cached.Answer, nil
+	}
+
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)}
+	completion, err := p.llm.GenerateContent(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("error getting response from LlmClient: %w", err)
+	}
+	notes := strings.TrimSpace(completion.Choices[0].Content)
+	if cacheable(p, completion) {
+		modelName := producingModel(completion, p.modelName)
+		app.cacheLlmAnswer(cacheKey, fmt.Sprintf("notes on part %d of %d by %s", chunkNumber, chunkCount, modelName), cachedAnswer{Answer: notes, Model: modelName})
+	}
+	return notes, nil
+}
+
+// chunkPromptData returns the data the chunk template is rendered with
+func chunkPromptData(content string, chunkNumber int, chunkCount int, languageName string) map[string]interface{} {
+	return map[string]interface{}{
+		"Language":       languageName,
+		"Content":        content,
+		"ChunkNumber":    chunkNumber,
+		"ChunkCount":     chunkCount,
+		"PromptPreamble": config.PromptPreamble,
+	}
+}
+
+// countPromptTokens renders a prompt template and returns its number of tokens
+func countPromptTokens(prompt *template.Template, data map[string]interface{}) (int, error) {
+	var promptBuffer bytes.Buffer
+	if err := prompt.Execute(&promptBuffer, data); err != nil {
+		return 0, fmt.Errorf("error executing template %s: %v", prompt.Name(), err)
+	}
+	return countTokens(promptBuffer.String()), nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestContentBudget(t *testing.T) {
+	tests := []struct {
+		name          string
+		contextWindow int
+		promptTokens  int
+		budget        int
+	}{
+		{name: "prompt fits", contextWindow: 8192, promptTokens: 1000, budget: 8192 - 1024 - 1000},
+		{name: "prompt too long", contextWindow: 2048, promptTokens: 1000, budget: minContentTokens},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &pipeline{name: "test", contextWindow: test.contextWindow, responseTokens: 1024}
+			if budget := p.contentBudget(test.promptTokens); budget != test.budget {
+				t.Errorf("contentBudget(%d) = %d, want %d", test.promptTokens, budget, test.budget)
+			}
+		})
+	}
+}
+
+func TestFitContent(t *testing.T) {
+	longContent := strings.Repeat(strings.Repeat("Lorem ipsum dolor sit amet. ", 40)+"\n\n", 10)
+
+	tests := []struct {
+		name      string
+		content   string
+		notes     string
+		maxTokens int
+		condensed bool
+	}{
+		{name: "content fits", content: "Rechnung Nr. 12345", notes: "notes", maxTokens: 100},
+		{name: "content is condensed", content: longContent, notes: "Invoice of Amazon", maxTokens: 1000, condensed: true},
+		{name: "notes are truncated", content: longContent, notes: strings.Repeat("Long notes. ", 400), maxTokens: 300, condensed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := &scriptedModel{answer: test.notes}
+			p := &pipeline{name: "test", llm: model, modelName: "test", contextWindow: 2048, responseTokens: 512}
+
+			content, err := (&App{}).fitContent(context.Background(), p, 1, test.content, test.maxTokens, "English")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tokens := countTokens(content); tokens > test.maxTokens {
+				t.Errorf("content has %d tokens, more than %d", tokens, test.maxTokens)
+			}
+			if !test.condensed {
+				if content != test.content || model.requests != 0 {
+					t.Errorf("content was changed with %d requests: %q", model.requests, content)
+				}
+				return
+			}
+			if model.requests == 0 || !strings.Contains(content, "Notes on part 1 of") {
+				t.Errorf("content was not condensed with %d requests: %q", model.requests, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/embedding"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+	"time"
+
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// embeddingBatchSize is the number of documents embedded with a single request
+const embeddingBatchSize = 16
+
+// createEmbedder creates the embedding client of EMBEDDING_PROVIDER, which shares the connection settings of the LLM providers
+func createEmbedder(provider string, model string) (embeddings.Embedder, error) {
+	var client embeddings.EmbedderClient
+	var err error
+	switch provider {
+	case "openai", "openai-compatible", "azure":
+		options, optionsErr := openAIOptions(provider, model)
+		if optionsErr != nil {
+			return nil, optionsErr
+		}
+		embeddingModel := model
+		if provider == "azure" {
+			embeddingModel = config.AzureDeployment(model)
+		}
+		client, err = openai.New(append(options, openai.WithEmbeddingModel(embeddingModel))...)
+	case "ollama":
+		client, err = ollama.New(ollamaOptions(model)...)
+	default:
+		return nil, fmt.Errorf("provider %s does not support embeddings", provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Line breaks separate the paragraphs of documents, so they are kept
+	return embeddings.NewEmbedder(client, embeddings.WithBatchSize(embeddingBatchSize), embeddings.WithStripNewLines(false))
+}
+
+// embeddingText returns the text of a document which is embedded, limited to EMBEDDING_MAX_TOKENS, and its hash
+func embeddingText(document paperless_model.Document) (string, string) {
+	text := strings.TrimSpace(document.Title + "\n\n" + document.Content)
+	if chunks := splitText(text, config.EmbeddingMaxTokens); len(chunks) > 0 {
+		text = chunks[0]
+	}
+	hash := sha256.Sum256([]byte(text))
+	return text, hex.EncodeToString(hash[:])
+}
+
+// runEmbeddingSync keeps the embedding index in sync with paperless-ngx until ctx is cancelled
+func (app *App) runEmbeddingSync(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := app.syncEmbeddings(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("Error syncing embedding index: %v", err)
+			}
+			timer.Reset(config.EmbeddingSyncInterval)
+		}
+	}
+}
+
+// syncEmbeddings embeds all documents modified since the last sync whose text changed, and removes deleted documents.
+// The index is saved after every page, so an interrupted sync does not start over.
+func (app *App) syncEmbeddings(ctx context.Context) error {
+	started := time.Now()
+	since := app.embeddingIndex.SyncedUntil()
+	embeddedCount := 0
+
+	for page := 1; ; page++ {
+		documents, hasNextPage, err := app.PaperlessClient.GetDocumentsModifiedSince(ctx, since, page, config.PageSize)
+		if err != nil {
+			return fmt.Errorf("error fetching modified documents: %w", err)
+		}
+
+		var changed []embedding.Entry
+		var texts []string
+		for _, document := range documents {
+			text, hash := embeddingText(document)
+			if entry, found := app.embeddingIndex.Get(document.ID); (found && entry.ContentHash == hash) || text == "" {
+				continue
+			}
+			changed = append(changed, embedding.Entry{DocumentID: document.ID, ContentHash: hash})
+			texts = append(texts, text)
+		}
+
+		if len(texts) > 0 {
+			vectors, err := app.embedder.EmbedDocuments(ctx, texts)
+			if err != nil {
+				return fmt.Errorf("error embedding documents: %w", err)
+			}
+			if len(vectors) != len(changed) {
+				return fmt.Errorf("error embedding documents: got %d embeddings for %d documents", len(vectors), len(changed))
+			}
+			for i := range changed {
+				changed[i].Vector = vectors[i]
+			}
+			if err := app.embeddingIndex.Put(changed); err != nil {
+				return err
+			}
+			embeddedCount += len(changed)
+		}
+
+		if !hasNextPage {
+			break
+		}
+	}
+
+	// Documents deleted in paperless-ngx are removed from the index
+	documentIDs, err := app.PaperlessClient.GetAllDocumentIDs(ctx)
+	if err != nil {
+		return err
+	}
+	removedCount, err := app.embeddingIndex.Retain(documentIDs, started)
+	if err != nil {
+		return err
+	}
+
+	if embeddedCount > 0 || removedCount > 0 {
+		log.Infof("Embedding index synced: %d documents embedded, %d removed, %d in total", embeddedCount, removedCount, app.embeddingIndex.Len())
+	}
+	return nil
+}
+
+// nearestDocuments returns up to count documents of the index most similar to the given document.
+// The embedding of the index is used if it is up to date, otherwise the document is embedded.
+func (app *App) nearestDocuments(ctx context.Context, document paperless_model.Document, count int) ([]embedding.Match, error) {
+	text, hash := embeddingText(document)
+	if text == "" {
+		return nil, nil
+	}
+
+	entry, found := app.embeddingIndex.Get(document.ID)
+	vector := entry.Vector
+	if !found || entry.ContentHash != hash {
+		var err error
+		if vector, err = app.embedder.EmbedQuery(ctx, text); err != nil {
+			return nil, fmt.Errorf("error embedding document %d: %w", document.ID, err)
+		}
+	}
+
+	return app.embeddingIndex.Nearest(vector, count, func(documentID int) bool {
+		return documentID == document.ID
+	}), nil
+}
+
+// semanticSearch returns up to count documents most similar to a query
+func (app *App) semanticSearch(ctx context.Context, query string, count int) ([]embedding.Match, error) {
+	vector, err := app.embedder.EmbedQuery(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+	return app.embeddingIndex.Nearest(vector, count, nil), nil
+}
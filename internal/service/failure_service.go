@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"paperless-gpt/paperless/paperless_service"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// staleFailureAge is how long after its next attempt was due a failure is forgotten, e.g. because the document
+// lost its trigger tag or was deleted
+const staleFailureAge = 7 * 24 * time.Hour
+
+// documentFailure is the failure history of a single document
+type documentFailure struct {
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// failureTracker counts failed attempts per document, so failing documents are retried with a backoff
+// while the remaining documents keep being processed. The counts are persisted, so a restart does not
+// give failing documents new attempts.
+type failureTracker struct {
+	path     string
+	failures map[int]documentFailure
+	mutex    sync.Mutex
+}
+
+// newFailureTracker creates a tracker backed by the given file and loads the failures which were stored before
+func newFailureTracker(path string) (*failureTracker, error) {
+	tracker := &failureTracker{path: path, failures: make(map[int]documentFailure)}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return tracker, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading failures %s: %w", path, err)
+	}
+	if err := json.Unmarshal(content, &tracker.failures); err != nil {
+		return nil, fmt.Errorf("error parsing failures %s: %w", path, err)
+	}
+	for documentID, failure := range tracker.failures {
+		if time.Since(failure.NextAttempt) > staleFailureAge {
+			delete(tracker.failures, documentID)
+		}
+	}
+
+	if len(tracker.failures) > 0 {
+		log.Infof("Loaded failed attempts of %d documents from %s", len(tracker.failures), path)
+	}
+	return tracker, nil
+}
+
+// isBackingOff reports whether the document failed recently and must not be retried yet
+func (tracker *failureTracker) isBackingOff(documentID int) bool {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	failure, found := tracker.failures[documentID]
+	return found && time.Now().Before(failure.NextAttempt)
+}
+
+// recordFailure counts a failed attempt and returns the number of attempts so far
+func (tracker *failureTracker) recordFailure(documentID int, err error) int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	failure := tracker.failures[documentID]
+	failure.Attempts++
+	failure.LastError = err.Error()
+	// Exponential backoff per document, starting with the configured duration
+	failure.NextAttempt = time.Now().Add(config.DocumentRetryBackoff * time.Duration(1<<(failure.Attempts-1)))
+	tracker.failures[documentID] = failure
+
+	tracker.save()
+	return failure.Attempts
+}
+
+// postpone lets the document wait until the given time without counting a failed attempt
+func (tracker *failureTracker) postpone(documentID int, until time.Time) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	failure := tracker.failures[documentID]
+	failure.NextAttempt = until
+	tracker.failures[documentID] = failure
+
+	tracker.save()
+}
+
+// reset forgets the failures of a document
+func (tracker *failureTracker) reset(documentID int) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if _, found := tracker.failures[documentID]; !found {
+		return
+	}
+	delete(tracker.failures, documentID)
+
+	tracker.save()
+}
+
+// save writes all failures to a temporary file and renames it, so a crash never leaves a truncated file.
+// Failures which are not persisted are still counted in memory, so errors are only logged. The caller must hold the mutex.
+func (tracker *failureTracker) save() {
+	for documentID, failure := range tracker.failures {
+		if time.Since(failure.NextAttempt) > staleFailureAge {
+			delete(tracker.failures, documentID)
+		}
+	}
+
+	content, err := json.MarshalIndent(tracker.failures, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshalling failures: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(tracker.path), os.ModePerm); err != nil {
+		log.Errorf("Error creating directory for failures: %v", err)
+		return
+	}
+
+	tempPath := tracker.path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0o600); err != nil {
+		log.Errorf("Error writing failures: %v", err)
+		return
+	}
+	if err := os.Rename(tempPath, tracker.path); err != nil {
+		log.Errorf("Error replacing failures: %v", err)
+	}
+}
+
+// isEligible reports whether a document may be processed by the pipeline right now
+func (app *App) isEligible(p *pipeline, document paperless_model.Document) bool {
+	// Check for blacklisted tags
+	for _, tag := range document.Tags {
+		for _, blacklistedTag := range p.tagBlackList {
+			if tag == blacklistedTag {
+				log.Debugf("Document with id: '%d' has a blacklisted tag: '%s'. Skipping auto-tagging.", document.ID, tag)
+				return false
+			}
+		}
+	}
+
+	if app.dryRunDone.contains(document.ID) {
+		log.Debugf("Document %d was already processed in dry-run mode, skipping.", document.ID)
+		return false
+	}
+
+	if app.failures.isBackingOff(document.ID) {
+		log.Debugf("Document %d failed recently, skipping until its backoff has passed.", document.ID)
+		return false
+	}
+
+	return true
+}
+
+// handleDocumentFailure records a failed attempt. After the configured number of attempts the trigger tag
+// of the document is replaced by the failed tag and the error is added as note. The tags are taken from the current
+// document, so tags changed while it was processed are kept.
+func (app *App) handleDocumentFailure(ctx context.Context, p *pipeline, document paperless_model.Document, processingError error) {
+	attempts := app.failures.recordFailure(document.ID, processingError)
+	if attempts < config.MaxDocumentAttempts {
+		log.Warnf("Attempt %d of %d failed for document %d in pipeline %s: %v", attempts, config.MaxDocumentAttempts, document.ID, p.name, processingError)
+		return
+	}
+
+	log.Errorf("Giving up on document %d in pipeline %s after %d attempts: %v", document.ID, p.name, attempts, processingError)
+
+	current, err := app.PaperlessClient.GetDocument(ctx, document.ID)
+	if err != nil {
+		log.Errorf("Error fetching document %d for tagging it as failed: %v", document.ID, err)
+		return
+	}
+
+	failedTags := paperless_service.RemoveTagFromList(current.Tags, p.tagName)
+	failedTags = append(failedTags, config.FailedTag)
+	if err := app.PaperlessClient.UpdateDocumentTags(ctx, document.ID, failedTags); err != nil {
+		log.Errorf("Error tagging document %d as failed: %v", document.ID, err)
+		return
+	}
+
+	note := fmt.Sprintf("paperless-gpt: processing in pipeline '%s' failed after %d attempts: %v", p.name, attempts, processingError)
+	if err := app.PaperlessClient.AddNote(ctx, document.ID, note); err != nil {
+		log.Errorf("Error adding failure note to document %d: %v", document.ID, err)
+	}
+
+	app.failures.reset(document.ID)
+	if config.DryRun {
+		app.dryRunDone.tryAdd(document.ID)
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Keys of the GenerationInfo of a response holding the model and the backend which produced it
+const (
+	generationInfoModel   = "paperless_gpt_model"
+	generationInfoBackend = "paperless_gpt_backend"
+)
+
+// llmUnavailableError is returned if the circuit breakers of all backends are open, so no request was sent
+type llmUnavailableError struct {
+	retryAt time.Time
+}
+
+func (err *llmUnavailableError) Error() string {
+	return fmt.Sprintf("all LLM backends are unavailable until %s", err.retryAt.Format(time.RFC3339))
+}
+
+// circuitBreaker stops sending requests to a backend after repeated failures. Once the cooldown has passed,
+// a single request tests whether the backend recovered, while all other requests keep skipping it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a request may be sent. Every allowed request must be followed by success, failure or release.
+func (breaker *circuitBreaker) allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.failures < breaker.threshold {
+		return true
+	}
+	if breaker.probing || time.Now().Before(breaker.openUntil) {
+		return false
+	}
+	breaker.probing = true
+	return true
+}
+
+// success closes the breaker
+func (breaker *circuitBreaker) success() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.failures = 0
+	breaker.probing = false
+}
+
+// failure counts a failed request and reports whether the breaker is open now
+func (breaker *circuitBreaker) failure() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.failures++
+	breaker.probing = false
+	if breaker.failures >= breaker.threshold {
+		breaker.openUntil = time.Now().Add(breaker.cooldown)
+		return true
+	}
+	return false
+}
+
+// release gives up an allowed request without a result, e.g. because it was cancelled by the caller
+func (breaker *circuitBreaker) release() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.probing = false
+}
+
+// closesAt returns the time after which the breaker lets a request through again
+func (breaker *circuitBreaker) closesAt() time.Time {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	return breaker.openUntil
+}
+
+// llmBackend is a model of an LLM provider with its own limiter and circuit breaker, shared by all pipelines using it
+type llmBackend struct {
+	name           string
+	modelName      string
+	contextWindow  int
+	responseTokens int
+	model          llms.Model
+	breaker        *circuitBreaker
+	// generationKey describes the generation options of the backend, see config.LlmProviderSettings.GenerationKey
+	generationKey string
+}
+
+// fallbackModel sends every request to the first available backend of an ordered list.
+// Failed requests are repeated with the next backend, and backends failing repeatedly are skipped until their cooldown has passed.
+type fallbackModel struct {
+	backends []*llmBackend
+}
+
+func (model *fallbackModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	var backendErrors []error
+	var retryAt time.Time
+
+	for _, backend := range model.backends {
+		if !backend.breaker.allow() {
+			log.Debugf("Skipping LLM backend %s, its circuit breaker is open", backend.name)
+			if closesAt := backend.breaker.closesAt(); retryAt.IsZero() || closesAt.Before(retryAt) {
+				retryAt = closesAt
+			}
+			continue
+		}
+
+		response, err := backend.model.GenerateContent(ctx, messages, options...)
+		if err == nil && len(response.Choices) == 0 {
+			err = fmt.Errorf("empty response")
+		}
+		if err == nil {
+			backend.breaker.success()
+			if response.Choices[0].GenerationInfo == nil {
+				response.Choices[0].GenerationInfo = make(map[string]any)
+			}
+			response.Choices[0].GenerationInfo[generationInfoModel] = backend.modelName
+			response.Choices[0].GenerationInfo[generationInfoBackend] = backend.name
+			return response, nil
+		}
+
+		if ctx.Err() != nil {
+			// Cancelled by the caller, which says nothing about the backend
+			backend.breaker.release()
+			return nil, err
+		}
+
+		if backend.breaker.failure() {
+			log.Warnf("LLM backend %s failed repeatedly, skipping it for %v: %v", backend.name, config.LlmBreakerCooldown, err)
+		} else {
+			log.Warnf("LLM backend %s failed: %v", backend.name, err)
+		}
+		backendErrors = append(backendErrors, fmt.Errorf("%s: %w", backend.name, err))
+	}
+
+	if len(backendErrors) == 0 {
+		return nil, &llmUnavailableError{retryAt: retryAt}
+	}
+	return nil, errors.Join(backendErrors...)
+}
+
+func (model *fallbackModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, model, prompt, options...)
+}
+
+// contextWindow returns the smallest context window of all backends, so prompts fit whichever backend answers them
+func (model *fallbackModel) contextWindow() int {
+	smallest := 0
+	for _, backend := range model.backends {
+		if smallest == 0 || backend.contextWindow < smallest {
+			smallest = backend.contextWindow
+		}
+	}
+	return smallest
+}
+
+// responseTokens returns the largest number of tokens any backend keeps free for its answer
+func (model *fallbackModel) responseTokens() int {
+	largest := 0
+	for _, backend := range model.backends {
+		largest = max(largest, backend.responseTokens)
+	}
+	return largest
+}
+
+// producingModel returns the model which produced a response of a fallbackModel
+func producingModel(response *llms.ContentResponse, defaultModel string) string {
+	if modelName, found := response.Choices[0].GenerationInfo[generationInfoModel].(string); found {
+		return modelName
+	}
+	return defaultModel
+}
+
+// newFallbackModel creates a model trying the primary backend first and the fallbacks in the given order.
+// Backends are created once, so all pipelines using the same model share its limiter and circuit breaker.
+func (app *App) newFallbackModel(primary config.LlmBackend, fallbacks []config.LlmBackend) (*fallbackModel, error) {
+	model := &fallbackModel{}
+	seen := make(map[string]bool)
+	for _, definition := range append([]config.LlmBackend{primary}, fallbacks...) {
+		if seen[definition.String()] {
+			continue
+		}
+		seen[definition.String()] = true
+
+		backend, found := app.llmBackends[definition.String()]
+		if !found {
+			llm, err := createLLM(definition.Provider, definition.Model)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create LlmClient client %s: %w", definition, err)
+			}
+			settings := config.ProviderSettings(definition.Provider)
+			backend = &llmBackend{
+				name:           definition.String(),
+				modelName:      definition.Model,
+				contextWindow:  contextWindow(definition),
+				responseTokens: responseTokens(definition),
+				model:          newLimitedModel(llm, config.LlmMaxConcurrency, generationOptions(settings)),
+				breaker:        newCircuitBreaker(config.LlmBreakerThreshold, config.LlmBreakerCooldown),
+				generationKey:  settings.GenerationKey(),
+			}
+			app.llmBackends[definition.String()] = backend
+		}
+		model.backends = append(model.backends, backend)
+	}
+	return model, nil
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// scriptedModel answers with the given text, or fails with err, and counts its requests
+type scriptedModel struct {
+	answer   string
+	err      error
+	requests int
+}
+
+func (model *scriptedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	model.requests++
+	if model.err != nil {
+		return nil, model.err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: model.answer}}}, nil
+}
+
+func (model *scriptedModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, model, prompt, options...)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name string
+		// steps are applied in order: "allow", "deny", "success", "failure", "open" (failure opening the breaker),
+		// "release" and "wait" (for the cooldown)
+		steps []string
+	}{
+		{
+			name:  "stays closed below the threshold",
+			steps: []string{"allow", "failure", "allow", "success", "allow", "failure", "allow"},
+		},
+		{
+			name:  "opens at the threshold",
+			steps: []string{"allow", "failure", "allow", "open", "deny", "deny"},
+		},
+		{
+			name:  "single probe after the cooldown",
+			steps: []string{"allow", "failure", "allow", "open", "wait", "allow", "deny", "success", "allow", "allow"},
+		},
+		{
+			name:  "failed probe opens again",
+			steps: []string{"allow", "failure", "allow", "open", "wait", "allow", "open", "deny"},
+		},
+		{
+			name:  "released probe allows another probe",
+			steps: []string{"allow", "failure", "allow", "open", "wait", "allow", "deny", "release", "allow"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := newCircuitBreaker(2, 20*time.Millisecond)
+			for i, step := range test.steps {
+				switch step {
+				case "allow", "deny":
+					if allowed := breaker.allow(); allowed != (step == "allow") {
+						t.Fatalf("step %d: allow() = %t", i, allowed)
+					}
+				case "success":
+					breaker.success()
+				case "failure", "open":
+					if open := breaker.failure(); open != (step == "open") {
+						t.Fatalf("step %d: failure() = %t", i, open)
+					}
+				case "release":
+					breaker.release()
+				case "wait":
+					time.Sleep(time.Until(breaker.closesAt()) + time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackModel(t *testing.T) {
+	failure := errors.New("server error")
+
+	tests := []struct {
+		name     string
+		models   []*scriptedModel
+		answer   string
+		backend  string
+		requests []int
+		failing  bool
+	}{
+		{
+			name:     "primary answers",
+			models:   []*scriptedModel{{answer: "primary"}, {answer: "fallback"}},
+			answer:   "primary",
+			backend:  "backend-0",
+			requests: []int{1, 0},
+		},
+		{
+			name:     "fallback answers failed request",
+			models:   []*scriptedModel{{err: failure}, {answer: "fallback"}},
+			answer:   "fallback",
+			backend:  "backend-1",
+			requests: []int{1, 1},
+		},
+		{
+			name:     "all backends fail",
+			models:   []*scriptedModel{{err: failure}, {err: failure}},
+			requests: []int{1, 1},
+			failing:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			model := &fallbackModel{}
+			for i, scripted := range test.models {
+				model.backends = append(model.backends, &llmBackend{
+					name:      fmt.Sprintf("backend-%d", i),
+					modelName: fmt.Sprintf("model-%d", i),
+					model:     scripted,
+					breaker:   newCircuitBreaker(3, time.Minute),
+				})
+			}
+
+			response, err := model.GenerateContent(context.Background(), nil)
+			for i, scripted := range test.models {
+				if scripted.requests != test.requests[i] {
+					t.Errorf("backend %d got %d requests, want %d", i, scripted.requests, test.requests[i])
+				}
+			}
+			if test.failing {
+				if err == nil || !errors.Is(err, failure) {
+					t.Fatalf("error = %v, want the errors of all backends", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			choice := response.Choices[0]
+			if choice.Content != test.answer || choice.GenerationInfo[generationInfoBackend] != test.backend {
+				t.Errorf("answer %q of %v, want %q of %s", choice.Content, choice.GenerationInfo[generationInfoBackend], test.answer, test.backend)
+			}
+		})
+	}
+}
+
+func TestFallbackModelSkipsOpenBreakers(t *testing.T) {
+	primary := &scriptedModel{err: errors.New("server error")}
+	fallback := &scriptedModel{answer: "fallback"}
+	model := &fallbackModel{backends: []*llmBackend{
+		{name: "primary", modelName: "primary", model: primary, breaker: newCircuitBreaker(1, time.Minute)},
+		{name: "fallback", modelName: "fallback", model: fallback, breaker: newCircuitBreaker(1, time.Minute)},
+	}}
+
+	for i := 0; i < 3; i++ {
+		response, err := model.GenerateContent(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modelName := producingModel(response, "primary"); modelName != "fallback" {
+			t.Errorf("request %d answered by %s", i, modelName)
+		}
+	}
+	if primary.requests != 1 {
+		t.Errorf("primary got %d requests, want 1 before its breaker opened", primary.requests)
+	}
+
+	fallback.err = errors.New("server error")
+	if _, err := model.GenerateContent(context.Background(), nil); err == nil {
+		t.Fatal("expected the error of the fallback")
+	}
+	_, err := model.GenerateContent(context.Background(), nil)
+	var unavailable *llmUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("error = %v, want llmUnavailableError", err)
+	}
+	if unavailable.retryAt.Before(time.Now()) {
+		t.Errorf("retry at %v is in the past", unavailable.retryAt)
+	}
+}
+
+func TestFallbackModelCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary := &scriptedModel{err: context.Canceled}
+	fallback := &scriptedModel{answer: "fallback"}
+	model := &fallbackModel{backends: []*llmBackend{
+		{name: "primary", model: primary, breaker: newCircuitBreaker(1, time.Minute)},
+		{name: "fallback", model: fallback, breaker: newCircuitBreaker(1, time.Minute)},
+	}}
+
+	if _, err := model.GenerateContent(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if fallback.requests != 0 {
+		t.Error("cancelled request was repeated with the fallback")
+	}
+	if !model.backends[0].breaker.allow() {
+		t.Error("cancelled request counted as failure of the primary")
+	}
+}
+
+func TestFallbackModelLimits(t *testing.T) {
+	model := &fallbackModel{backends: []*llmBackend{
+		{contextWindow: 8192, responseTokens: 512},
+		{contextWindow: 4096, responseTokens: 1024},
+		{contextWindow: 128000, responseTokens: 256},
+	}}
+
+	if window := model.contextWindow(); window != 4096 {
+		t.Errorf("contextWindow() = %d, want the smallest window 4096", window)
+	}
+	if tokens := model.responseTokens(); tokens != 1024 {
+		t.Errorf("responseTokens() = %d, want the largest value 1024", tokens)
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+)
+
+// maxSimilarDocuments limits how many similar documents are fetched to find enough examples which are already filed
+const maxSimilarDocuments = 50
+
+// fewShotExample is an already filed document shown to the LLM together with the answer matching how it was filed
+type fewShotExample struct {
+	Content string
+	// Json is the answer the LLM would have to give for this document
+	Json string
+}
+
+// fewShotExamples returns up to FEW_SHOT_EXAMPLES documents similar to the given one, found by the embedding index or,
+// if embeddings are disabled, by the more_like_id search of paperless-ngx. Documents which are still processed, waiting
+// for a review or failed are skipped, since their metadata was not confirmed. Tags outside of availableTags are left out
+// of the examples.
+func (app *App) fewShotExamples(ctx context.Context, document paperless_model.Document, availableTags []string) []fewShotExample {
+	if config.FewShotExamples == 0 {
+		return nil
+	}
+
+	similarDocuments, err := app.similarDocuments(ctx, document, min(config.FewShotExamples*3, maxSimilarDocuments))
+	if err != nil {
+		// The suggestion works without examples, just less consistently
+		log.Warnf("Failed to fetch documents similar to document %d: %v", document.ID, err)
+		return nil
+	}
+
+	unfiledTags := app.unfiledTags()
+	examples := make([]fewShotExample, 0, config.FewShotExamples)
+	for _, similarDocument := range similarDocuments {
+		if len(examples) == config.FewShotExamples {
+			break
+		}
+		if similarDocument.ID == document.ID || containsAny(similarDocument.Tags, unfiledTags) {
+			continue
+		}
+		chunks := splitText(similarDocument.Content, config.FewShotSnippetTokens)
+		if len(chunks) == 0 {
+			continue
+		}
+
+		tags := []string{}
+		for _, tag := range similarDocument.Tags {
+			if containsString(availableTags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		var answer bytes.Buffer
+		encoder := json.NewEncoder(&answer)
+		// Names like "Rechnungen & Belege" must reach the LLM as they are
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(struct {
+			Correspondent string   `json:"correspondent"`
+			DocumentType  string   `json:"document_type"`
+			Title         string   `json:"title"`
+			CreatedDate   string   `json:"created_date"`
+			Tags          []string `json:"tags"`
+		}{similarDocument.Correspondent, similarDocument.DocumentType, similarDocument.Title, similarDocument.CreatedDate, tags})
+		if err != nil {
+			continue
+		}
+		examples = append(examples, fewShotExample{Content: chunks[0], Json: strings.TrimSpace(answer.String())})
+	}
+
+	log.Debugf("Found %d examples for document %d among %d similar documents", len(examples), document.ID, len(similarDocuments))
+	return examples
+}
+
+// unfiledTags returns the tags of documents whose metadata was not confirmed yet
+func (app *App) unfiledTags() []string {
+	return append(app.triggerTags(), config.PendingTag, config.RejectedTag, config.FailedTag, config.NeedsReviewTag)
+}
+
+// similarDocuments returns up to count documents similar to the given one, the most similar first
+func (app *App) similarDocuments(ctx context.Context, document paperless_model.Document, count int) ([]paperless_model.Document, error) {
+	if app.embeddingIndex == nil {
+		return app.PaperlessClient.GetSimilarDocuments(ctx, document.ID, count)
+	}
+
+	matches, err := app.nearestDocuments(ctx, document, count)
+	if err != nil {
+		return nil, err
+	}
+	documentIDs := make([]int, 0, len(matches))
+	for _, match := range matches {
+		documentIDs = append(documentIDs, match.DocumentID)
+	}
+	return app.PaperlessClient.GetDocumentsByIDs(ctx, documentIDs)
+}
+
+// containsAny reports whether list contains any of the values
+func containsAny(list []string, values []string) bool {
+	for _, value := range values {
+		if containsString(list, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/language"
+	"text/template"
+)
+
+// languagePrompt is the json template chosen for the language of a document
+type languagePrompt struct {
+	template *template.Template
+	version  string
+	// language is the name of the language passed to the template as .Language
+	language string
+	// detected is the detected language, empty if the language was not detected
+	detected string
+}
+
+// documentLanguage detects the language of a document with DETECT_LANGUAGE. It reports false if detection is
+// disabled or the content is too short or mixed to tell.
+func documentLanguage(documentID int, content string) (language.Language, bool) {
+	if !config.DetectLanguage {
+		return language.Language{}, false
+	}
+	detected, found := language.Detect(content, config.DetectLanguages...)
+	if !found {
+		log.Debugf("Could not detect the language of document %d, assuming %s", documentID, config.GetLikelyLanguage())
+		return language.Language{}, false
+	}
+	log.Debugf("Detected %s as language of document %d", detected.Name, documentID)
+	return detected, true
+}
+
+// languageName returns the name of the language of a document, LLM_LANGUAGE if it is not detected
+func languageName(documentID int, content string) string {
+	if detected, found := documentLanguage(documentID, content); found {
+		return detected.Name
+	}
+	return config.GetLikelyLanguage()
+}
+
+// languagePrompt picks the language variant of the json template of the pipeline for the language of a document,
+// e.g. json_prompt_EN.tmpl for English documents. Without a detected language or a variant for it the template of
+// the pipeline is used.
+func (p *pipeline) languagePrompt(documentID int, content string) languagePrompt {
+	current := p.prompt.Current()
+	selected := languagePrompt{template: current.Template, version: current.Version, language: config.GetLikelyLanguage()}
+	detected, found := documentLanguage(documentID, content)
+	if !found {
+		return selected
+	}
+
+	selected.language = detected.Name
+	selected.detected = detected.Name
+	if variant := p.promptVariants.get(detected.Code); variant.Template != nil {
+		selected.template, selected.version = variant.Template, variant.Version
+		log.Infof("Using the %s prompt template %s for document %d", detected.Name, variant.Template.Name(), documentID)
+	}
+	return selected
+}
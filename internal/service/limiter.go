@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/limiter"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// limitedModel bounds the number of concurrent requests to an LLM provider and the duration of each request,
+// and adds the generation options of the provider to every request
+type limitedModel struct {
+	llms.Model
+	limiter *limiter.Limiter
+	timeout time.Duration
+	options []llms.CallOption
+}
+
+// newLimitedModel wraps a model so that at most maxConcurrency requests are sent at the same time,
+// each aborted after LLM_TIMEOUT. Waiting for a free slot does not count towards the timeout.
+func newLimitedModel(model llms.Model, maxConcurrency int, options []llms.CallOption) *limitedModel {
+	return &limitedModel{
+		Model:   model,
+		limiter: limiter.New(maxConcurrency),
+		timeout: config.LlmTimeout,
+		options: options,
+	}
+}
+
+func (model *limitedModel) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if err := model.limiter.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer model.limiter.Release()
+
+	ctx, cancel := context.WithTimeout(ctx, model.timeout)
+	defer cancel()
+
+	// Options of the caller are applied last, so they override the configured ones
+	options = append(append([]llms.CallOption{}, model.options...), options...)
+	return model.Model.GenerateContent(ctx, messages, options...)
+}
+
+func (model *limitedModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, model, prompt, options...)
+}
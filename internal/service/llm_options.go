@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+	"paperless-gpt/internal/config"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// llmTransport builds the HTTP transport of an LLM provider: the extra headers of the provider are always set,
+// the structured output transport of the provider is only added if structured output is enabled.
+// Without apiKeyHeader the authentication headers set by the client are left as they are.
+func llmTransport(settings config.LlmProviderSettings, apiKeyHeader string, structuredOutput func(base http.RoundTripper) http.RoundTripper) http.RoundTripper {
+	var transport http.RoundTripper = &headerTransport{
+		base:         http.DefaultTransport,
+		apiKey:       settings.APIKey,
+		apiKeyHeader: apiKeyHeader,
+		headers:      settings.ExtraHeaders,
+	}
+	if config.StructuredOutput && structuredOutput != nil {
+		transport = structuredOutput(transport)
+	}
+	return transport
+}
+
+// headerTransport sets the API key in a custom header and adds extra headers, e.g. for gateways in front of the LLM
+type headerTransport struct {
+	base         http.RoundTripper
+	apiKey       string
+	apiKeyHeader string
+	headers      map[string]string
+}
+
+func (transport *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request is cloned, since a RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+	switch {
+	case transport.apiKeyHeader == "":
+	case transport.apiKey == "":
+		// Servers without authentication must not receive the placeholder token required by langchaingo
+		req.Header.Del("Authorization")
+	case transport.apiKeyHeader != "Authorization":
+		req.Header.Del("Authorization")
+		req.Header.Set(transport.apiKeyHeader, transport.apiKey)
+	}
+	for name, value := range transport.headers {
+		req.Header.Set(name, value)
+	}
+	return transport.base.RoundTrip(req)
+}
+
+// generationOptions returns the generation options of a provider, passed to every request of its backends.
+// Options which are not configured are left to the provider.
+func generationOptions(settings config.LlmProviderSettings) []llms.CallOption {
+	var options []llms.CallOption
+	if settings.Temperature != nil {
+		options = append(options, llms.WithTemperature(*settings.Temperature))
+	}
+	if settings.TopP != nil {
+		options = append(options, llms.WithTopP(*settings.TopP))
+	}
+	if settings.Seed != nil {
+		options = append(options, llms.WithSeed(*settings.Seed))
+	}
+	if settings.MaxTokens > 0 {
+		options = append(options, llms.WithMaxTokens(settings.MaxTokens))
+	}
+	return options
+}
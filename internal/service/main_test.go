@@ -0,0 +1,23 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"paperless-gpt/internal/config"
+	"testing"
+)
+
+// TestMain loads the default templates from a fresh PROMPTS_DIR, so no templates of earlier runs are used
+func TestMain(m *testing.M) {
+	promptsDir, err := os.MkdirTemp("", "paperless-gpt-prompts")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create prompts directory: %v\n", err)
+		os.Exit(1)
+	}
+	os.Setenv("PROMPTS_DIR", promptsDir)
+	config.LoadTemplates()
+
+	code := m.Run()
+	os.RemoveAll(promptsDir)
+	os.Exit(code)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// queueSize is the number of documents a pipeline buffers from webhooks before rejecting further requests
+const queueSize = 100
+
+// pipeline processes all documents carrying its trigger tag with the steps of its definition
+type pipeline struct {
+	name            string
+	definition      config.PipelineDefinition
+	tagName         string
+	customFieldName string
+	tagBlackList    []string
+	workers         int
+	prompt          *config.Prompt
+	summaryPrompt   *config.Prompt
+	llm             llms.Model
+	modelName       string
+	contextWindow   int
+	responseTokens  int
+	queue           chan int
+	jobs            chan job
+	// promptVariants are the language variants of the json template, only loaded with DETECT_LANGUAGE
+	promptVariants *promptVariants
+}
+
+// job is a document handed to a worker of a pipeline. done is called with the number of processed documents once it is finished.
+type job struct {
+	document paperless_model.Document
+	done     func(processedCount int)
+}
+
+// newPipeline creates a pipeline with an empty webhook queue from its definition.
+// Pipelines without their own templates, LLM or fallbacks use the default ones of the app.
+func (app *App) newPipeline(definition config.PipelineDefinition) (*pipeline, error) {
+	p := &pipeline{
+		name:            definition.Name,
+		definition:      definition,
+		tagName:         definition.TriggerTag,
+		customFieldName: definition.CustomField,
+		tagBlackList:    definition.TagBlackList,
+		workers:         definition.Workers,
+		prompt:          config.JsonPrompt,
+		summaryPrompt:   config.SummaryPrompt,
+		llm:             app.LlmClient,
+		modelName:       config.LlmModel,
+		queue:           make(chan int, queueSize),
+		jobs:            make(chan job),
+	}
+
+	var err error
+	if definition.PromptTemplate != "" {
+		if p.prompt, err = app.openPrompt(definition.PromptTemplate); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", definition.Name, err)
+		}
+	}
+	if config.DetectLanguage {
+		if p.promptVariants, err = app.openPromptVariants(p.prompt.FileName()); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", definition.Name, err)
+		}
+	}
+	if definition.SummaryTemplate != "" {
+		if p.summaryPrompt, err = app.openPrompt(definition.SummaryTemplate); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", definition.Name, err)
+		}
+	}
+	if definition.LlmModel != "" || definition.LlmFallbacks != nil {
+		primary := config.LlmBackend{Provider: strings.ToLower(config.LlmProvider), Model: config.LlmModel}
+		if definition.LlmModel != "" {
+			primary = config.LlmBackend{Provider: strings.ToLower(definition.LlmProvider), Model: definition.LlmModel}
+		}
+		fallbacks := config.LlmFallbacks
+		if definition.LlmFallbacks != nil {
+			fallbacks = make([]config.LlmBackend, 0, len(definition.LlmFallbacks))
+			for _, value := range definition.LlmFallbacks {
+				fallback, err := config.ParseLlmBackend(value)
+				if err != nil {
+					return nil, fmt.Errorf("pipeline %s: %w", definition.Name, err)
+				}
+				fallbacks = append(fallbacks, fallback)
+			}
+		}
+
+		if p.llm, err = app.newFallbackModel(primary, fallbacks); err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", definition.Name, err)
+		}
+		p.modelName = primary.Model
+	}
+
+	primary := config.LlmBackend{Provider: strings.ToLower(config.LlmProvider), Model: config.LlmModel}
+	p.contextWindow, p.responseTokens = contextWindow(primary), responseTokens(primary)
+	if model, ok := p.llm.(*fallbackModel); ok {
+		p.contextWindow, p.responseTokens = model.contextWindow(), model.responseTokens()
+	}
+
+	return p, nil
+}
+
+// isTriggeredBy reports whether the document carries the trigger tag of the pipeline
+func (p *pipeline) isTriggeredBy(document paperless_model.Document) bool {
+	for _, tag := range document.Tags {
+		if tag == p.tagName {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue adds a document to the queue of the pipeline without blocking. It returns false if the queue is full.
+func (p *pipeline) enqueue(documentID int) bool {
+	select {
+	case p.queue <- documentID:
+		return true
+	default:
+		return false
+	}
+}
+
+// documentSet tracks the documents which are currently processed, so no document is processed twice at the same time
+type documentSet struct {
+	documents map[int]struct{}
+	mutex     sync.Mutex
+}
+
+func newDocumentSet() *documentSet {
+	return &documentSet{documents: make(map[int]struct{})}
+}
+
+// tryAdd adds the document and returns false if it is already in the set
+func (set *documentSet) tryAdd(documentID int) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	if _, found := set.documents[documentID]; found {
+		return false
+	}
+	set.documents[documentID] = struct{}{}
+	return true
+}
+
+// remove deletes the document from the set
+func (set *documentSet) remove(documentID int) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	delete(set.documents, documentID)
+}
+
+// contains reports whether the document is in the set
+func (set *documentSet) contains(documentID int) bool {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	_, found := set.documents[documentID]
+	return found
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+	"text/template"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// runPipeline runs the steps of the pipeline for a document and combines their results into one suggestion,
+// restricted to the fields the pipeline may write
+func (app *App) runPipeline(ctx context.Context, p *pipeline, document paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
+	existingTags := append([]string{}, document.Tags...)
+	suggestion := &paperless_model.DocumentSuggestion{
+		DocumentID:       document.ID,
+		OriginalDocument: document,
+		Tags:             &existingTags,
+	}
+
+	// Later steps work on the text extracted by an OCR step
+	content := document.Content
+
+	for _, step := range p.definition.Steps {
+		switch step {
+		case config.StepOcr:
+			extractedText, err := app.extractText(ctx, document)
+			if err != nil {
+				return nil, err
+			}
+			suggestion.Content = &extractedText
+			content = extractedText
+
+		case config.StepClassify:
+			documentWithContent := document
+			documentWithContent.Content = content
+			classification, err := app.generateAutoDocumentSuggestion(ctx, p, documentWithContent)
+			if err != nil {
+				return nil, err
+			}
+			suggestion.Title = classification.Title
+			suggestion.Tags = classification.Tags
+			suggestion.Correspondent = classification.Correspondent
+			suggestion.DocumentType = classification.DocumentType
+			suggestion.Date = classification.Date
+			suggestion.Model = classification.Model
+			suggestion.PromptVersion = classification.PromptVersion
+			suggestion.Confidence = classification.Confidence
+			suggestion.Language = classification.Language
+
+		case config.StepSummary:
+			summaryPrompt := p.summaryPrompt.Current()
+			summary, modelName, err := app.generateSummary(ctx, p, summaryPrompt.Template, document.ID, content)
+			if err != nil {
+				return nil, err
+			}
+			suggestion.Summary = &summary
+			suggestion.Model = modelName
+			if suggestion.PromptVersion == "" {
+				suggestion.PromptVersion = summaryPrompt.Version
+			}
+		}
+	}
+
+	restrictFields(p.definition, suggestion, document)
+	return suggestion, nil
+}
+
+// restrictFields drops every suggested field the pipeline may not write. Tags it may not write are kept as they are,
+// so that only the trigger and completion tags change.
+func restrictFields(definition config.PipelineDefinition, suggestion *paperless_model.DocumentSuggestion, document paperless_model.Document) {
+	if !definition.Writes(config.FieldTitle) {
+		suggestion.Title = nil
+	}
+	if !definition.Writes(config.FieldTags) {
+		existingTags := append([]string{}, document.Tags...)
+		suggestion.Tags = &existingTags
+	}
+	if !definition.Writes(config.FieldCorrespondent) {
+		suggestion.Correspondent = nil
+	}
+	if !definition.Writes(config.FieldDocumentType) {
+		suggestion.DocumentType = nil
+	}
+	if !definition.Writes(config.FieldCreatedDate) {
+		suggestion.Date = nil
+	}
+	if !definition.Writes(config.FieldContent) {
+		suggestion.Content = nil
+	}
+	if !definition.Writes(config.FieldNotes) {
+		suggestion.Summary = nil
+	}
+}
+
+// generateSummary asks the LlmClient of the pipeline for a short summary of the document, which is added as note.
+// It returns the summary and the model which wrote it.
+func (app *App) generateSummary(ctx context.Context, p *pipeline, summaryPrompt *template.Template, documentID int, content string) (string, string, error) {
+	documentLanguage := languageName(documentID, content)
+	promptTokens, err := countPromptTokens(summaryPrompt, summaryPromptData(documentLanguage, ""))
+	if err != nil {
+		return "", "", err
+	}
+	content, err = app.fitContent(ctx, p, documentID, content, p.contentBudget(promptTokens), documentLanguage)
+	if err != nil {
+		return "", "", err
+	}
+
+	var promptBuffer bytes.Buffer
+	if err := summaryPrompt.Execute(&promptBuffer, summaryPromptData(documentLanguage, content)); err != nil {
+		return "", "", fmt.Errorf("error executing summary template: %v", err)
+	}
+
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, promptBuffer.String())}
+	completion, err := p.llm.GenerateContent(ctx, messages)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting summary of document %d from LlmClient: %w", documentID, err)
+	}
+
+	summary := completion.Choices[0].Content
+	log.Debugf("Summary of document %d: %s", documentID, summary)
+	return strings.TrimSpace(summary), producingModel(completion, p.modelName), nil
+}
+
+// summaryPromptData returns the data the summary template is rendered with
+func summaryPromptData(languageName string, content string) map[string]interface{} {
+	return map[string]interface{}{
+		"Language":        languageName,
+		"Content":         content,
+		"PromptPreamble":  config.PromptPreamble,
+		"PromptPostamble": config.PromptPostamble,
+	}
+}
+
+// triggerTags returns the trigger tags of all pipelines
+func (app *App) triggerTags() []string {
+	tags := make([]string, 0, len(app.pipelines))
+	for _, p := range app.pipelines {
+		tags = append(tags, p.tagName)
+	}
+	return tags
+}
+
+// classificationPipeline returns the first pipeline which classifies documents, used for suggestions requested over the API
+func (app *App) classificationPipeline() *pipeline {
+	for _, p := range app.pipelines {
+		if p.definition.HasStep(config.StepClassify) {
+			return p
+		}
+	}
+	return nil
+}
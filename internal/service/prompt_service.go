@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/ocr"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// sampleContent is the document content templates are test-rendered with
+const sampleContent = "Amazon EU S.a.r.l.\nRechnung Nr. 12345 vom 03.02.2024\n1x USB-C Kabel 12,99 EUR\nGesamtbetrag 12,99 EUR"
+
+// watchedPrompt is a template which is test-rendered with sample data before it is used
+type watchedPrompt struct {
+	prompt *config.Prompt
+	// sample returns data like the data the template is rendered with
+	sample func() map[string]interface{}
+}
+
+// validate renders a template with the sample data, which fails for syntax the parser accepts, like calls of unknown
+// methods, and for variables which are not passed to the template
+func (watched watchedPrompt) validate(parsedTemplate *template.Template) error {
+	if err := parsedTemplate.Execute(io.Discard, watched.sample()); err != nil {
+		return fmt.Errorf("failed to render sample data: %w", err)
+	}
+	return nil
+}
+
+// sampleJsonPromptData renders the optional sections of the json template as well
+func sampleJsonPromptData() map[string]interface{} {
+	examples := []fewShotExample{{
+		Content: "Amazon EU S.a.r.l.\nRechnung Nr. 11111\nGesamtbetrag 30,00 EUR",
+		Json:    `{"title":"Rechnung Bestellung 11111","correspondent":"Amazon","document_type":"Rechnung","created_date":"2023-05-01","tags":["Rechnungen & Belege"]}`,
+	}}
+	data := jsonPromptData(config.GetLikelyLanguage(), sampleContent, []string{"Rechnungen & Belege", "Versicherung & Vorsorge"},
+		[]string{"Amazon", "Stadtwerke"}, config.CorrespondentBlackList, []string{"paperless-gpt"}, []string{"Rechnung", "Vertrag"}, examples)
+	data["ConfidenceRequested"] = true
+	return data
+}
+
+func sampleSummaryPromptData() map[string]interface{} {
+	return summaryPromptData(config.GetLikelyLanguage(), sampleContent)
+}
+
+func sampleChunkPromptData() map[string]interface{} {
+	return chunkPromptData(sampleContent, 1, 2, config.GetLikelyLanguage())
+}
+
+func sampleOcrPromptData() map[string]interface{} {
+	return ocr.PromptData(1, 2)
+}
+
+// watchedPrompts returns every template in use, each once
+func (app *App) watchedPrompts() []watchedPrompt {
+	watched := []watchedPrompt{
+		{prompt: config.ChunkPrompt, sample: sampleChunkPromptData},
+		{prompt: config.OcrPrompt, sample: sampleOcrPromptData},
+	}
+	for _, p := range app.pipelines {
+		watched = append(watched, watchedPrompt{prompt: p.prompt, sample: sampleJsonPromptData}, watchedPrompt{prompt: p.summaryPrompt, sample: sampleSummaryPromptData})
+		if p.promptVariants != nil {
+			for _, variant := range p.promptVariants.all() {
+				watched = append(watched, watchedPrompt{prompt: variant, sample: sampleJsonPromptData})
+			}
+		}
+	}
+
+	seen := make(map[*config.Prompt]bool, len(watched))
+	unique := watched[:0]
+	for _, prompt := range watched {
+		if !seen[prompt.prompt] {
+			seen[prompt.prompt] = true
+			unique = append(unique, prompt)
+		}
+	}
+	return unique
+}
+
+// validatePrompts test-renders every template in use, so broken templates are reported at startup instead of
+// failing every document
+func (app *App) validatePrompts() error {
+	for _, watched := range app.watchedPrompts() {
+		if err := watched.validate(watched.prompt.Current().Template); err != nil {
+			return fmt.Errorf("prompt template %s is invalid: %w", watched.prompt.FileName(), err)
+		}
+	}
+	return nil
+}
+
+// runPromptReload reloads changed templates of PROMPTS_DIR every PROMPTS_RELOAD_INTERVAL until ctx is cancelled
+func (app *App) runPromptReload(ctx context.Context) {
+	ticker := time.NewTicker(config.PromptsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.reloadPrompts()
+		}
+	}
+}
+
+// reloadPrompts swaps in every template whose file changed, if it renders the sample data. A broken template is
+// rejected and the previous version stays active.
+func (app *App) reloadPrompts() {
+	for _, variants := range app.promptVariants {
+		variants.sync()
+	}
+
+	for _, watched := range app.watchedPrompts() {
+		previous := watched.prompt.Current()
+		reloaded, err := watched.prompt.Reload(watched.validate)
+		switch {
+		case err != nil && previous.Template == nil:
+			log.Errorf("Rejected new prompt template %s, it is not used until it is fixed: %v", watched.prompt.FileName(), err)
+		case err != nil:
+			log.Errorf("Rejected change of prompt template %s, version %s stays active: %v", watched.prompt.FileName(), previous.Version, err)
+		case reloaded:
+			log.Infof("Reloaded prompt template %s, version %s", watched.prompt.FileName(), watched.prompt.Current().Version)
+		}
+	}
+}
+
+// defaultPrompts returns the default templates by file name
+func defaultPrompts() map[string]*config.Prompt {
+	prompts := make(map[string]*config.Prompt)
+	for _, prompt := range []*config.Prompt{config.JsonPrompt, config.SummaryPrompt, config.OcrPrompt, config.ChunkPrompt} {
+		prompts[prompt.FileName()] = prompt
+	}
+	return prompts
+}
+
+// openPrompt returns the template of a file of PROMPTS_DIR, shared by all pipelines using it, so it is reloaded once
+func (app *App) openPrompt(fileName string) (*config.Prompt, error) {
+	if prompt, found := app.prompts[fileName]; found {
+		return prompt, nil
+	}
+	prompt, err := config.OpenPrompt(fileName)
+	if err != nil {
+		return nil, err
+	}
+	app.prompts[fileName] = prompt
+	return prompt, nil
+}
+
+// promptVariants are the language variants of a json template by language code
+type promptVariants struct {
+	// fileName is the file of the json template
+	fileName string
+	variants map[string]*config.Prompt
+	mutex    sync.RWMutex
+}
+
+// openPromptVariants loads the language variants of a json template, shared by all pipelines using the template
+func (app *App) openPromptVariants(fileName string) (*promptVariants, error) {
+	if variants, found := app.promptVariants[fileName]; found {
+		return variants, nil
+	}
+
+	fileNames, err := config.LanguageVariants(fileName)
+	if err != nil {
+		return nil, err
+	}
+	variants := &promptVariants{fileName: fileName, variants: make(map[string]*config.Prompt, len(fileNames))}
+	for code, variantName := range fileNames {
+		if variants.variants[code], err = config.OpenPrompt(variantName); err != nil {
+			return nil, err
+		}
+	}
+	app.promptVariants[fileName] = variants
+	return variants, nil
+}
+
+// get returns the variant for a language code. Its Template is nil if there is no variant for the language,
+// or it was never loaded.
+func (variants *promptVariants) get(code string) config.PromptTemplate {
+	if variants == nil {
+		return config.PromptTemplate{}
+	}
+	variants.mutex.RLock()
+	defer variants.mutex.RUnlock()
+
+	if variant, found := variants.variants[code]; found {
+		return variant.Current()
+	}
+	return config.PromptTemplate{}
+}
+
+// all returns every variant
+func (variants *promptVariants) all() []*config.Prompt {
+	variants.mutex.RLock()
+	defer variants.mutex.RUnlock()
+
+	all := make([]*config.Prompt, 0, len(variants.variants))
+	for _, variant := range variants.variants {
+		all = append(all, variant)
+	}
+	return all
+}
+
+// sync adds the variants which were added to PROMPTS_DIR, to be loaded by the next reload, and drops the removed ones
+func (variants *promptVariants) sync() {
+	fileNames, err := config.LanguageVariants(variants.fileName)
+	if err != nil {
+		log.Errorf("Failed to list language variants of prompt template %s: %v", variants.fileName, err)
+		return
+	}
+
+	variants.mutex.Lock()
+	defer variants.mutex.Unlock()
+
+	for code, fileName := range fileNames {
+		if variant, found := variants.variants[code]; !found || variant.FileName() != fileName {
+			variants.variants[code] = config.NewPrompt(fileName)
+		}
+	}
+	for code, variant := range variants.variants {
+		if _, found := fileNames[code]; !found {
+			log.Infof("Prompt template %s was removed, documents in its language use %s", variant.FileName(), variants.fileName)
+			delete(variants.variants, code)
+		}
+	}
+}
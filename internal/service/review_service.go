@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/model"
+	"paperless-gpt/internal/review"
+	"paperless-gpt/paperless/paperless_model"
+	"paperless-gpt/paperless/paperless_service"
+)
+
+// requiresReview reports whether a suggestion must be reviewed manually, which is the case
+// when the document or the suggestion carries one of the configured review tags
+func requiresReview(document paperless_model.Document, suggestion paperless_model.DocumentSuggestion) bool {
+	tags := append([]string{}, document.Tags...)
+	if suggestion.Tags != nil {
+		tags = append(tags, *suggestion.Tags...)
+	}
+
+	for _, tag := range tags {
+		for _, reviewTag := range config.ReviewTags {
+			if tag == reviewTag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queueForReview stores the suggestion for a manual review and swaps the trigger tag of the
+// document for the pending tag, so it is not picked up again while waiting for the review
+func (app *App) queueForReview(ctx context.Context, suggestion paperless_model.DocumentSuggestion, triggerTag string, customFieldName string) error {
+	err := app.ReviewStore.Add(review.PendingSuggestion{
+		Suggestion:      suggestion,
+		TriggerTag:      triggerTag,
+		CustomFieldName: customFieldName,
+	})
+	if err != nil {
+		return fmt.Errorf("error storing suggestion for review: %w", err)
+	}
+
+	pendingTags := paperless_service.RemoveTagFromList(suggestion.OriginalDocument.Tags, triggerTag)
+	pendingTags = append(pendingTags, config.PendingTag)
+	if err := app.PaperlessClient.UpdateDocumentTags(ctx, suggestion.DocumentID, pendingTags); err != nil {
+		return fmt.Errorf("error tagging document %d as pending: %w", suggestion.DocumentID, err)
+	}
+
+	log.Infof("Suggestion for document %d queued for review", suggestion.DocumentID)
+	return nil
+}
+
+// updatePendingSuggestion changes the given fields of a pending suggestion
+func (app *App) updatePendingSuggestion(documentID int, request model.UpdateSuggestionRequest) (review.PendingSuggestion, error) {
+	return app.ReviewStore.Update(documentID, func(pending *review.PendingSuggestion) {
+		if request.Title != nil {
+			pending.Suggestion.Title = request.Title
+		}
+		if request.Correspondent != nil {
+			pending.Suggestion.Correspondent = request.Correspondent
+		}
+		if request.DocumentType != nil {
+			pending.Suggestion.DocumentType = request.DocumentType
+		}
+		if request.Date != nil {
+			pending.Suggestion.Date = request.Date
+		}
+		if request.Tags != nil {
+			pending.Suggestion.Tags = request.Tags
+		}
+	})
+}
+
+// approveSuggestion applies a pending suggestion to paperless-ngx and removes it from the review queue
+func (app *App) approveSuggestion(ctx context.Context, documentID int) error {
+	pending, err := app.ReviewStore.Take(documentID)
+	if err != nil {
+		return err
+	}
+
+	current, err := app.PaperlessClient.GetDocument(ctx, documentID)
+	if err != nil {
+		app.returnToQueue(pending)
+		return fmt.Errorf("error fetching document %d: %w", documentID, err)
+	}
+
+	// The suggestion is applied to the current document, so changes made while it was pending are kept
+	suggestion := pending.Suggestion
+	tags := reviewedTags(current.Tags, pending)
+	suggestion.Tags = &tags
+	suggestion.OriginalDocument = current
+	if err := app.PaperlessClient.UpdateDocument(ctx, suggestion, pending.CustomFieldName); err != nil {
+		var applied *paperless_service.UpdateAppliedError
+		if !errors.As(err, &applied) {
+			app.returnToQueue(pending)
+		}
+		return fmt.Errorf("error applying suggestion for document %d: %w", documentID, err)
+	}
+
+	log.Infof("Suggestion for document %d approved", documentID)
+	return nil
+}
+
+// rejectSuggestion discards a pending suggestion and marks the document with the rejected tag
+func (app *App) rejectSuggestion(ctx context.Context, documentID int) error {
+	pending, err := app.ReviewStore.Take(documentID)
+	if err != nil {
+		return err
+	}
+
+	current, err := app.PaperlessClient.GetDocument(ctx, documentID)
+	if err != nil {
+		app.returnToQueue(pending)
+		return fmt.Errorf("error fetching document %d: %w", documentID, err)
+	}
+
+	rejectedTags := paperless_service.RemoveTagFromList(current.Tags, pending.TriggerTag)
+	rejectedTags = paperless_service.RemoveTagFromList(rejectedTags, config.PendingTag)
+	rejectedTags = append(paperless_service.RemoveTagFromList(rejectedTags, config.RejectedTag), config.RejectedTag)
+	if err := app.PaperlessClient.UpdateDocumentTags(ctx, documentID, rejectedTags); err != nil {
+		app.returnToQueue(pending)
+		return fmt.Errorf("error tagging document %d as rejected: %w", documentID, err)
+	}
+
+	log.Infof("Suggestion for document %d rejected", documentID)
+	return nil
+}
+
+// returnToQueue puts a suggestion back into the review queue after applying or rejecting it failed
+func (app *App) returnToQueue(pending review.PendingSuggestion) {
+	if err := app.ReviewStore.Add(pending); err != nil {
+		log.Errorf("Error returning the suggestion for document %d to the review queue: %v", pending.Suggestion.DocumentID, err)
+	}
+}
+
+// reviewedTags applies the tag changes of a reviewed suggestion to the current tags of a document. The trigger and
+// pending tags are removed, as well as the tags the suggestion removed, and the tags it added are added. Tags changed in
+// paperless-ngx while the suggestion was pending are kept.
+func reviewedTags(current []string, pending review.PendingSuggestion) []string {
+	tags := paperless_service.RemoveTagFromList(current, pending.TriggerTag)
+	tags = paperless_service.RemoveTagFromList(tags, config.PendingTag)
+	if pending.Suggestion.Tags == nil {
+		return tags
+	}
+
+	original := pending.Suggestion.OriginalDocument.Tags
+	suggested := *pending.Suggestion.Tags
+	for _, tag := range original {
+		if !containsString(suggested, tag) {
+			tags = paperless_service.RemoveTagFromList(tags, tag)
+		}
+	}
+	for _, tag := range suggested {
+		if !containsString(original, tag) && !containsString(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/review"
+	"paperless-gpt/paperless/paperless_model"
+	"reflect"
+	"testing"
+)
+
+func TestReviewedTags(t *testing.T) {
+	pending := func(original []string, suggested []string) review.PendingSuggestion {
+		return review.PendingSuggestion{
+			Suggestion: paperless_model.DocumentSuggestion{
+				OriginalDocument: paperless_model.Document{Tags: original},
+				Tags:             &suggested,
+			},
+			TriggerTag: "paperless-gpt",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		current []string
+		pending review.PendingSuggestion
+		tags    []string
+	}{
+		{
+			name:    "unchanged document",
+			current: []string{"inbox", config.PendingTag},
+			pending: pending([]string{"inbox", "paperless-gpt"}, []string{"inbox", "invoice"}),
+			tags:    []string{"inbox", "invoice"},
+		},
+		{
+			name:    "tags changed while pending",
+			current: []string{"urgent", config.PendingTag},
+			pending: pending([]string{"inbox", "paperless-gpt"}, []string{"inbox", "invoice"}),
+			tags:    []string{"urgent", "invoice"},
+		},
+		{
+			name:    "tag removed in the review",
+			current: []string{"inbox", "scan", config.PendingTag},
+			pending: pending([]string{"inbox", "scan", "paperless-gpt"}, []string{"inbox", "invoice"}),
+			tags:    []string{"inbox", "invoice"},
+		},
+		{
+			name:    "suggested tag added meanwhile",
+			current: []string{"invoice", config.PendingTag},
+			pending: pending([]string{"paperless-gpt"}, []string{"invoice"}),
+			tags:    []string{"invoice"},
+		},
+		{
+			name:    "no tags suggested",
+			current: []string{"inbox", "paperless-gpt", config.PendingTag},
+			pending: review.PendingSuggestion{TriggerTag: "paperless-gpt"},
+			tags:    []string{"inbox"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if tags := reviewedTags(test.current, test.pending); !reflect.DeepEqual(tags, test.tags) {
+				t.Errorf("reviewedTags() = %q, want %q", tags, test.tags)
+			}
+		})
+	}
+}
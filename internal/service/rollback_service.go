@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/journal"
+	"paperless-gpt/paperless/paperless_model"
+	"paperless-gpt/paperless/paperless_service"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rollbackFilter selects the journal entries to revert
+type rollbackFilter struct {
+	documentID    int
+	since         time.Time
+	until         time.Time
+	model         string
+	promptVersion string
+}
+
+// matches reports whether a journal entry is selected by the filter
+func (filter rollbackFilter) matches(entry journal.Entry) bool {
+	// Reverting a rollback is done by rolling back the original change again
+	if entry.Source == journal.SourceRollback {
+		return false
+	}
+	if filter.documentID != 0 && entry.DocumentID != filter.documentID {
+		return false
+	}
+	if !filter.since.IsZero() && entry.Timestamp.Before(filter.since) {
+		return false
+	}
+	if !filter.until.IsZero() && !entry.Timestamp.Before(filter.until) {
+		return false
+	}
+	if filter.model != "" && entry.Model != filter.model {
+		return false
+	}
+	if filter.promptVersion != "" && entry.PromptVersion != filter.promptVersion {
+		return false
+	}
+	return true
+}
+
+// documentRollback is the restore of a document to its state before the selected changes
+type documentRollback struct {
+	documentID int
+	// state holds the value of every field in fields before the first selected change of the field
+	state  journal.DocumentState
+	fields []string
+	// conflicts are the later changes of the document which are not selected, but may be undone by the rollback
+	conflicts       []journal.Entry
+	createdEntities []paperless_model.CreatedEntity
+}
+
+// selectRollbacks returns the rollbacks of the documents changed by the selected entries, ordered by document id.
+// Only the fields which the selected entries changed are restored.
+func selectRollbacks(entries []journal.Entry, filter rollbackFilter) []*documentRollback {
+	rollbacks := make(map[int]*documentRollback)
+	for _, entry := range entries {
+		rollback, found := rollbacks[entry.DocumentID]
+		if !filter.matches(entry) {
+			// Rollbacks are only repeated by rolling back again, other changes after a selected one are conflicts
+			if found && entry.Source != journal.SourceRollback {
+				rollback.conflicts = append(rollback.conflicts, entry)
+			}
+			continue
+		}
+
+		if !found {
+			rollback = &documentRollback{documentID: entry.DocumentID}
+			rollbacks[entry.DocumentID] = rollback
+		}
+		// The journal is in chronological order, so the first selected entry changing a field holds its value before
+		// all selected changes
+		for field := range entry.Changes {
+			if !slices.Contains(rollback.fields, field) && rollback.state.CopyField(field, entry.Previous) {
+				rollback.fields = append(rollback.fields, field)
+			}
+		}
+		rollback.createdEntities = append(rollback.createdEntities, entry.CreatedEntities...)
+	}
+
+	selected := make([]*documentRollback, 0, len(rollbacks))
+	for _, rollback := range rollbacks {
+		sort.Strings(rollback.fields)
+		selected = append(selected, rollback)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].documentID < selected[j].documentID
+	})
+	return selected
+}
+
+// describeConflicts lists later changes of a document for the log
+func describeConflicts(conflicts []journal.Entry) string {
+	descriptions := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		description := fmt.Sprintf("%s at %s", conflict.Source, conflict.Timestamp.Format(time.RFC3339))
+		if conflict.Model != "" {
+			description += " by " + conflict.Model
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// Rollback restores documents to the state recorded in the journal, called by the "rollback" command
+func Rollback(args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	documentID := flags.Int("document", 0, "revert the changes of a single document")
+	since := flags.String("since", "", "revert changes made at or after this time (RFC3339 or YYYY-MM-DD)")
+	until := flags.String("until", "", "revert changes made before this time (RFC3339 or YYYY-MM-DD)")
+	modelName := flags.String("model", "", "revert changes suggested by this model")
+	promptVersion := flags.String("prompt-version", "", "revert changes suggested with this prompt version")
+	deleteUnused := flags.Bool("delete-unused", false, "delete correspondents and document types created by paperless-gpt which are no longer used")
+	dryRun := flags.Bool("dry-run", config.DryRun, "report the changes instead of applying them")
+	force := flags.Bool("force", false, "also restore documents which were changed again after the selected changes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := rollbackFilter{documentID: *documentID, model: *modelName, promptVersion: *promptVersion}
+	var err error
+	if filter.since, err = parseRollbackTime(*since); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if filter.until, err = parseRollbackTime(*until); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+	if filter == (rollbackFilter{}) {
+		return fmt.Errorf("refusing to revert the whole journal, set at least one of -document, -since, -until, -model or -prompt-version")
+	}
+
+	client := paperless_service.NewPaperlessClient(config.PaperlessBaseURL, config.PaperlessAPIToken, config.PaperlessMaxConcurrency, config.PaperlessTimeout)
+	client.DryRun = *dryRun
+	client.DryRunReportPath = config.DryRunReportPath
+	client.Journal = journal.New(config.JournalPath)
+
+	entries, err := client.Journal.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	rollbacks := selectRollbacks(entries, filter)
+	if len(rollbacks) == 0 {
+		log.Infof("No journal entries match, nothing to revert.")
+		return nil
+	}
+
+	// A signal stops the rollback after the document in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var createdEntities []paperless_model.CreatedEntity
+	restored, skipped, failed := 0, 0, 0
+	for i, rollback := range rollbacks {
+		if ctx.Err() != nil {
+			remaining := make([]int, 0, len(rollbacks)-i)
+			for _, notRestored := range rollbacks[i:] {
+				remaining = append(remaining, notRestored.documentID)
+			}
+			return fmt.Errorf("rollback interrupted, documents %v were not restored", remaining)
+		}
+
+		if len(rollback.conflicts) > 0 {
+			if !*force {
+				log.Warnf("Skipping document %d, it was changed again after the selected changes (%s), use -force to restore it anyway",
+					rollback.documentID, describeConflicts(rollback.conflicts))
+				skipped++
+				continue
+			}
+			log.Warnf("Restoring document %d, although it was changed again after the selected changes (%s)",
+				rollback.documentID, describeConflicts(rollback.conflicts))
+		}
+		if *dryRun {
+			log.Infof("Dry run: would revert %s of document %d", strings.Join(rollback.fields, ", "), rollback.documentID)
+		}
+
+		if err := client.RestoreDocument(ctx, rollback.documentID, rollback.state, rollback.fields); err != nil {
+			log.Errorf("Error restoring document %d: %v", rollback.documentID, err)
+			failed++
+			continue
+		}
+		restored++
+		createdEntities = append(createdEntities, rollback.createdEntities...)
+	}
+	log.Infof("Restored %d of %d documents, %d skipped.", restored, len(rollbacks), skipped)
+
+	if *deleteUnused {
+		deleteUnusedEntities(ctx, client, createdEntities)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d documents could not be restored", failed)
+	}
+	return nil
+}
+
+// deleteUnusedEntities deletes the created correspondents and document types which no document uses anymore
+func deleteUnusedEntities(ctx context.Context, client *paperless_service.PaperlessClient, createdEntities []paperless_model.CreatedEntity) {
+	seen := make(map[paperless_model.CreatedEntity]bool)
+	for _, entity := range createdEntities {
+		// Entities without id were only reported by a dry run and never created
+		if entity.ID == nil {
+			continue
+		}
+		key := paperless_model.CreatedEntity{Type: entity.Type, Name: entity.Name}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		documentCount, err := client.EntityDocumentCount(ctx, entity.Type, *entity.ID)
+		if err != nil {
+			log.Errorf("Error checking usage of %s '%s': %v", entity.Type, entity.Name, err)
+			continue
+		}
+		if documentCount > 0 {
+			log.Infof("Keeping %s '%s', it is still used by %d documents.", entity.Type, entity.Name, documentCount)
+			continue
+		}
+		if err := client.DeleteEntity(ctx, entity.Type, *entity.ID); err != nil {
+			log.Errorf("Error deleting %s '%s': %v", entity.Type, entity.Name, err)
+		}
+	}
+}
+
+// parseRollbackTime parses a RFC3339 timestamp or a date, an empty value means no limit
+func parseRollbackTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if timestamp, err := time.Parse(time.RFC3339, value); err == nil {
+		return timestamp, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
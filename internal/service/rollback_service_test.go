@@ -0,0 +1,152 @@
+package service
+
+import (
+	"paperless-gpt/internal/journal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSelectRollbacks(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+	correspondent := 3
+
+	entries := []journal.Entry{
+		{
+			Timestamp: day(1), DocumentID: 1, Origin: journal.Origin{Source: journal.SourceSuggestion, Model: "gpt-4o"},
+			Previous: journal.DocumentState{Title: "Scan 1", Tags: []int{1}},
+			Changes:  map[string]interface{}{"title": "Invoice", "tags": []int{1, 2}},
+		},
+		{
+			Timestamp: day(2), DocumentID: 1, Origin: journal.Origin{Source: journal.SourceSuggestion, Model: "gpt-4o"},
+			Previous: journal.DocumentState{Title: "Invoice", Tags: []int{1, 2}, Correspondent: &correspondent},
+			Changes:  map[string]interface{}{"title": "Invoice 2", "correspondent": 5},
+		},
+		{
+			Timestamp: day(1), DocumentID: 2, Origin: journal.Origin{Source: journal.SourceSuggestion, Model: "gpt-4o"},
+			Previous: journal.DocumentState{Title: "Scan 2"},
+			Changes:  map[string]interface{}{"title": "Contract", "unknown": true},
+		},
+		{
+			Timestamp: day(3), DocumentID: 2, Origin: journal.Origin{Source: journal.SourceSuggestion, Model: "llama3"},
+			Previous: journal.DocumentState{Title: "Contract"},
+			Changes:  map[string]interface{}{"title": "Lease"},
+		},
+		{
+			Timestamp: day(3), DocumentID: 1, Origin: journal.Origin{Source: journal.SourceRollback},
+			Previous: journal.DocumentState{Title: "Invoice 2"},
+			Changes:  map[string]interface{}{"title": "Scan 1"},
+		},
+		{
+			// Changes before the first selected entry of a document are no conflicts
+			Timestamp: day(1), DocumentID: 3, Origin: journal.Origin{Source: journal.SourceTags},
+			Previous: journal.DocumentState{Title: "Scan 3"},
+			Changes:  map[string]interface{}{"tags": []int{4}},
+		},
+		{
+			Timestamp: day(2), DocumentID: 3, Origin: journal.Origin{Source: journal.SourceSuggestion, Model: "gpt-4o"},
+			Previous: journal.DocumentState{Title: "Scan 3", Tags: []int{4}},
+			Changes:  map[string]interface{}{"title": "Letter"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		filter    rollbackFilter
+		documents []int
+		fields    map[int][]string
+		titles    map[int]string
+		conflicts map[int]int
+	}{
+		{
+			name:      "all fields of the selected entries",
+			filter:    rollbackFilter{model: "gpt-4o"},
+			documents: []int{1, 2, 3},
+			fields:    map[int][]string{1: {"correspondent", "tags", "title"}, 2: {"title"}, 3: {"title"}},
+			titles:    map[int]string{1: "Scan 1", 2: "Scan 2", 3: "Scan 3"},
+			conflicts: map[int]int{2: 1},
+		},
+		{
+			name:      "later entries of the same filter are no conflicts",
+			filter:    rollbackFilter{documentID: 1},
+			documents: []int{1},
+			fields:    map[int][]string{1: {"correspondent", "tags", "title"}},
+			titles:    map[int]string{1: "Scan 1"},
+		},
+		{
+			name:      "time range",
+			filter:    rollbackFilter{since: day(2)},
+			documents: []int{1, 2, 3},
+			fields:    map[int][]string{1: {"correspondent", "title"}, 2: {"title"}, 3: {"title"}},
+			titles:    map[int]string{1: "Invoice", 2: "Contract", 3: "Scan 3"},
+		},
+		{
+			name:      "earlier changes of other filters",
+			filter:    rollbackFilter{model: "llama3"},
+			documents: []int{2},
+			fields:    map[int][]string{2: {"title"}},
+			titles:    map[int]string{2: "Contract"},
+		},
+		{
+			name:   "nothing selected",
+			filter: rollbackFilter{model: "mistral"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollbacks := selectRollbacks(entries, test.filter)
+
+			documents := []int{}
+			for _, rollback := range rollbacks {
+				documents = append(documents, rollback.documentID)
+				if !reflect.DeepEqual(rollback.fields, test.fields[rollback.documentID]) {
+					t.Errorf("document %d: fields %v, want %v", rollback.documentID, rollback.fields, test.fields[rollback.documentID])
+				}
+				if rollback.state.Title != test.titles[rollback.documentID] {
+					t.Errorf("document %d: title %q, want %q", rollback.documentID, rollback.state.Title, test.titles[rollback.documentID])
+				}
+				if len(rollback.conflicts) != test.conflicts[rollback.documentID] {
+					t.Errorf("document %d: %d conflicts, want %d", rollback.documentID, len(rollback.conflicts), test.conflicts[rollback.documentID])
+				}
+			}
+			if test.documents == nil {
+				test.documents = []int{}
+			}
+			if !reflect.DeepEqual(documents, test.documents) {
+				t.Errorf("documents %v, want %v", documents, test.documents)
+			}
+		})
+	}
+}
+
+func TestSelectRollbacksRestoresFirstValue(t *testing.T) {
+	correspondent := 3
+	entries := []journal.Entry{
+		{
+			DocumentID: 1, Origin: journal.Origin{Source: journal.SourceSuggestion},
+			Previous: journal.DocumentState{Title: "Scan", Tags: []int{1}},
+			Changes:  map[string]interface{}{"title": "Invoice"},
+		},
+		{
+			DocumentID: 1, Origin: journal.Origin{Source: journal.SourceSuggestion},
+			Previous: journal.DocumentState{Title: "Invoice", Tags: []int{1, 2}, Correspondent: &correspondent},
+			Changes:  map[string]interface{}{"title": "Invoice 2", "correspondent": 5},
+		},
+	}
+
+	rollbacks := selectRollbacks(entries, rollbackFilter{documentID: 1})
+	if len(rollbacks) != 1 {
+		t.Fatalf("got %d rollbacks, want 1", len(rollbacks))
+	}
+	state := rollbacks[0].state
+	if state.Title != "Scan" {
+		t.Errorf("title %q, want the value before the first change", state.Title)
+	}
+	if state.Correspondent == nil || *state.Correspondent != correspondent {
+		t.Errorf("correspondent %v, want %d", state.Correspondent, correspondent)
+	}
+	if state.Tags != nil {
+		t.Errorf("tags %v were not changed and must not be restored", state.Tags)
+	}
+}
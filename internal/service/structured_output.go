@@ -0,0 +1,275 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// generatedFields are the fields of a DocumentSuggestion generated by the LLM, all other fields are set by paperless-gpt.
+// The confidence of the fields is only requested if CONFIDENCE_THRESHOLD is set.
+var generatedFields = newGeneratedFields()
+
+// suggestionSchema is the json schema of the answer expected from the LLM, derived from DocumentSuggestion
+var suggestionSchema = newSuggestionSchema()
+
+func newGeneratedFields() []string {
+	fields := []string{"title", "correspondent", "document_type", "created_date", "tags"}
+	if config.ConfidenceThreshold != nil {
+		fields = append(fields, "confidence")
+	}
+	return fields
+}
+
+// newSuggestionSchema builds a json schema of the generated fields of DocumentSuggestion.
+// All fields are required and no other fields are allowed, as demanded by the strict mode of OpenAI.
+func newSuggestionSchema() map[string]interface{} {
+	properties := make(map[string]interface{})
+	suggestionType := reflect.TypeOf(paperless_model.DocumentSuggestion{})
+	for i := 0; i < suggestionType.NumField(); i++ {
+		field := suggestionType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		for _, generatedField := range generatedFields {
+			if name == generatedField {
+				properties[name] = schemaForType(field.Type)
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             generatedFields,
+		"additionalProperties": false,
+	}
+}
+
+// schemaForType returns the json schema of a go type, pointers are treated like the type they point to
+func schemaForType(goType reflect.Type) map[string]interface{} {
+	for goType.Kind() == reflect.Ptr {
+		goType = goType.Elem()
+	}
+
+	switch goType.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := make([]string, 0, goType.NumField())
+		for i := 0; i < goType.NumField(); i++ {
+			name := strings.Split(goType.Field(i).Tag.Get("json"), ",")[0]
+			properties[name] = schemaForType(goType.Field(i).Type)
+			required = append(required, name)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaForType(goType.Elem())}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// validateSuggestionJson checks the answer of the LLM against suggestionSchema and returns all violations
+func validateSuggestionJson(jsonStr string) []string {
+	decoder := json.NewDecoder(strings.NewReader(jsonStr))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []string{fmt.Sprintf("the answer is not valid JSON: %v", err)}
+	}
+
+	validationErrors := validateAgainstSchema(value, suggestionSchema, "")
+
+	// Dates and ranges are not validated by the schema, since the strict mode of OpenAI does not support patterns or limits
+	if object, isObject := value.(map[string]interface{}); isObject {
+		if date, isString := object["created_date"].(string); isString && date != "" {
+			if _, err := time.Parse("2006-01-02", date); err != nil {
+				validationErrors = append(validationErrors, fmt.Sprintf("created_date must be empty or a date in the format YYYY-MM-DD, got %q", date))
+			}
+		}
+		if confidence, isObject := object["confidence"].(map[string]interface{}); isObject {
+			fields := make([]string, 0, len(confidence))
+			for field := range confidence {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				if number, isNumber := confidence[field].(json.Number); isNumber {
+					if value, err := number.Float64(); err == nil && (value < 0 || value > 1) {
+						validationErrors = append(validationErrors, fmt.Sprintf("confidence.%s must be between 0 and 1, got %s", field, number))
+					}
+				}
+			}
+		}
+	}
+
+	return validationErrors
+}
+
+// validateAgainstSchema validates a decoded json value against the subset of json schema used by suggestionSchema
+func validateAgainstSchema(value interface{}, schema map[string]interface{}, path string) []string {
+	name := path
+	if name == "" {
+		name = "the answer"
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			return []string{fmt.Sprintf("%s must be a JSON object", name)}
+		}
+
+		// Fields of nested objects are named with their path, like "confidence.title"
+		fieldPath := func(key string) string {
+			if path == "" {
+				return key
+			}
+			return path + "." + key
+		}
+
+		var validationErrors []string
+		properties := schema["properties"].(map[string]interface{})
+		for _, required := range schema["required"].([]string) {
+			if _, found := object[required]; !found {
+				validationErrors = append(validationErrors, fmt.Sprintf("the field %q is missing", fieldPath(required)))
+			}
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertySchema, known := properties[key]
+			if !known {
+				validationErrors = append(validationErrors, fmt.Sprintf("the field %q is not allowed", fieldPath(key)))
+				continue
+			}
+			validationErrors = append(validationErrors, validateAgainstSchema(object[key], propertySchema.(map[string]interface{}), fieldPath(key))...)
+		}
+		return validationErrors
+
+	case "array":
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return []string{fmt.Sprintf("%s must be a JSON array", name)}
+		}
+
+		var validationErrors []string
+		for i, element := range array {
+			validationErrors = append(validationErrors, validateAgainstSchema(element, schema["items"].(map[string]interface{}), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return validationErrors
+
+	case "integer":
+		if number, isNumber := value.(json.Number); !isNumber || strings.ContainsAny(number.String(), ".eE") {
+			return []string{fmt.Sprintf("%s must be an integer", name)}
+		}
+
+	case "number":
+		if _, isNumber := value.(json.Number); !isNumber {
+			return []string{fmt.Sprintf("%s must be a number", name)}
+		}
+
+	case "string":
+		if _, isString := value.(string); !isString {
+			return []string{fmt.Sprintf("%s must be a string", name)}
+		}
+	}
+
+	return nil
+}
+
+// repairPrompt asks the LLM to correct its previous answer
+func repairPrompt(validationErrors []string) string {
+	return "Your answer is not valid. Correct the following errors and answer only with the corrected JSON object:\n- " + strings.Join(validationErrors, "\n- ")
+}
+
+// structuredOutputTransport replaces the generic JSON mode of requests sent by langchaingo with the json schema of a suggestion.
+// langchaingo can only request "any JSON" from OpenAI and Ollama, while both APIs accept a schema in the same field.
+type structuredOutputTransport struct {
+	base http.RoundTripper
+	// field is the name of the request field holding the response format, jsonMode its value in JSON mode
+	field    string
+	jsonMode string
+	schema   json.RawMessage
+}
+
+// newOpenAIStructuredOutputTransport returns a transport sending json_object requests as json_schema requests
+func newOpenAIStructuredOutputTransport(base http.RoundTripper) http.RoundTripper {
+	schema, _ := json.Marshal(map[string]interface{}{
+		"type": "json_schema",
+		"json_schema": map[string]interface{}{
+			"name":   "document_suggestion",
+			"strict": true,
+			"schema": suggestionSchema,
+		},
+	})
+	return &structuredOutputTransport{
+		base:     base,
+		field:    "response_format",
+		jsonMode: `{"type":"json_object"}`,
+		schema:   schema,
+	}
+}
+
+// newOllamaStructuredOutputTransport returns a transport sending requests with format "json" with the schema as format
+func newOllamaStructuredOutputTransport(base http.RoundTripper) http.RoundTripper {
+	schema, _ := json.Marshal(suggestionSchema)
+	return &structuredOutputTransport{
+		base:     base,
+		field:    "format",
+		jsonMode: `"json"`,
+		schema:   schema,
+	}
+}
+
+func (transport *structuredOutputTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Method != http.MethodPost {
+		return transport.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err == nil {
+		var compacted bytes.Buffer
+		if format, found := fields[transport.field]; found && json.Compact(&compacted, format) == nil && compacted.String() == transport.jsonMode {
+			fields[transport.field] = transport.schema
+			if rewritten, err := json.Marshal(fields); err == nil {
+				body = rewritten
+			}
+		}
+	}
+
+	// The request is cloned, since a RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return transport.base.RoundTrip(req)
+}
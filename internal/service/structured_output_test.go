@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/json"
+	"paperless-gpt/paperless/paperless_model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSuggestionJson(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		errors []string
+	}{
+		{
+			name:   "valid",
+			answer: `{"title":"Rechnung","correspondent":"Amazon","document_type":"Rechnung","created_date":"2024-02-03","tags":["Steuer"]}`,
+		},
+		{
+			name:   "empty date",
+			answer: `{"title":"Rechnung","correspondent":"","document_type":"","created_date":"","tags":[]}`,
+		},
+		{
+			name:   "missing and unknown fields",
+			answer: `{"title":"Rechnung","correspondent":"Amazon","document_type":"Rechnung","tags":[],"summary":"text"}`,
+			errors: []string{`the field "created_date" is missing`, `the field "summary" is not allowed`},
+		},
+		{
+			name:   "wrong types",
+			answer: `{"title":1,"correspondent":"Amazon","document_type":"Rechnung","created_date":"","tags":"Steuer"}`,
+			errors: []string{"tags must be a JSON array", "title must be a string"},
+		},
+		{
+			name:   "wrong element type",
+			answer: `{"title":"Rechnung","correspondent":"Amazon","document_type":"Rechnung","created_date":"","tags":["Steuer",2]}`,
+			errors: []string{"tags[1] must be a string"},
+		},
+		{
+			name:   "invalid date",
+			answer: `{"title":"Rechnung","correspondent":"Amazon","document_type":"Rechnung","created_date":"03.02.2024","tags":[]}`,
+			errors: []string{`created_date must be empty or a date in the format YYYY-MM-DD, got "03.02.2024"`},
+		},
+		{
+			name:   "not an object",
+			answer: `["Steuer"]`,
+			errors: []string{"the answer must be a JSON object"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if errors := validateSuggestionJson(test.answer); !reflect.DeepEqual(errors, test.errors) {
+				t.Errorf("errors = %q, want %q", errors, test.errors)
+			}
+		})
+	}
+}
+
+func TestValidateSuggestionJsonInvalidJson(t *testing.T) {
+	errors := validateSuggestionJson(`{"title":`)
+	if len(errors) != 1 || !strings.HasPrefix(errors[0], "the answer is not valid JSON") {
+		t.Errorf("errors = %q", errors)
+	}
+}
+
+func TestValidateAgainstSchemaConfidence(t *testing.T) {
+	schema := schemaForType(reflect.TypeOf(paperless_model.FieldConfidence{}))
+
+	tests := []struct {
+		name       string
+		confidence string
+		errors     []string
+	}{
+		{
+			name:       "valid",
+			confidence: `{"title":0.9,"correspondent":1,"document_type":0,"created_date":0.5,"tags":0.75}`,
+		},
+		{
+			name:       "missing field and string value",
+			confidence: `{"title":"high","correspondent":1,"document_type":0,"created_date":0.5}`,
+			errors:     []string{`the field "confidence.tags" is missing`, "confidence.title must be a number"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder := json.NewDecoder(strings.NewReader(test.confidence))
+			decoder.UseNumber()
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				t.Fatal(err)
+			}
+			if errors := validateAgainstSchema(value, schema, "confidence"); !reflect.DeepEqual(errors, test.errors) {
+				t.Errorf("errors = %q, want %q", errors, test.errors)
+			}
+		})
+	}
+}
+
+func TestValidateAgainstSchemaInteger(t *testing.T) {
+	schema := map[string]interface{}{"type": "integer"}
+	for value, valid := range map[string]bool{"42": true, "-1": true, "4.2": false, "1e3": false} {
+		errors := validateAgainstSchema(json.Number(value), schema, "count")
+		if (len(errors) == 0) != valid {
+			t.Errorf("validating %s: errors = %q, want valid %t", value, errors, valid)
+		}
+	}
+}
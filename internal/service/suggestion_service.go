@@ -5,7 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/model"
 	"paperless-gpt/internal/ocr"
 	"paperless-gpt/paperless/paperless_model"
 	paperless_service "paperless-gpt/paperless/paperless_service"
@@ -15,19 +17,122 @@ import (
 	"github.com/tmc/langchaingo/llms"
 )
 
-// getSuggestedJson generates a suggested json for a document using the LlmClient
-func (app *App) getSuggestedJson(ctx context.Context, content string, availableTags []string, availableCorrespondents []string, correspondentBlackList []string, tagBlackList []string, availableDocumentTypeNames []string, originalDocument paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
+// getSuggestedJson generates a suggested json for a document using the given json template and the LlmClient of the pipeline
+func (app *App) getSuggestedJson(ctx context.Context, p *pipeline, prompt languagePrompt, content string, availableTags []string, availableCorrespondents []string, correspondentBlackList []string, availableDocumentTypeNames []string, originalDocument paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
 	if strings.TrimSpace(content) == "" {
 		log.Warnf("Empty content for document %d", originalDocument.ID)
 		jsonStr := fmt.Sprintf(`{"title": "ERROR: %s"}`, originalDocument.Title)
 		return unmarshalSuggestion(jsonStr, originalDocument)
 	}
 
-	likelyLanguage := config.GetLikelyLanguage()
+	// Similar documents which are already filed show the LLM how documents like this one were filed before
+	examples := app.fewShotExamples(ctx, originalDocument, availableTags)
+
+	promptData := func(content string) map[string]interface{} {
+		return jsonPromptData(prompt.language, content, availableTags, availableCorrespondents, correspondentBlackList, p.tagBlackList, availableDocumentTypeNames, examples)
+	}
+
+	// The lists of tags, correspondents and document types count against the context window as well
+	promptTokens, err := countPromptTokens(prompt.template, promptData(""))
+	if err != nil {
+		return nil, err
+	}
+	content, err = app.fitContent(ctx, p, originalDocument.ID, content, p.contentBudget(promptTokens), prompt.language)
+	if err != nil {
+		return nil, err
+	}
 
 	var promptBuffer bytes.Buffer
-	err := config.JsonPrompt.Execute(&promptBuffer, map[string]interface{}{
-		"Language":                 likelyLanguage,
+	if err := prompt.template.Execute(&promptBuffer, promptData(content)); err != nil {
+		return nil, fmt.Errorf("error executing json template: %v", err)
+	}
+
+	renderedPrompt := promptBuffer.String()
+	log.Debugf("Json suggestion prompt: %s", renderedPrompt)
+	// The same prompt may be sent to different models by different pipelines
+	cacheKey := llmCacheKey(p, renderedPrompt)
+	vocabulary := newVocabulary(availableTags, correspondentBlackList)
+
+	if cached, found := app.cachedLlmAnswer(cacheKey); found {
+		log.Infof("Using cached suggestion for document %d", originalDocument.ID)
+		suggestion, err := unmarshalSuggestion(cached.Answer, originalDocument)
+		if err != nil {
+			return nil, err
+		}
+		suggestion.Model = cached.Model
+		// The cached answer may have been accepted with values outside of the vocabulary
+		vocabulary.enforce(suggestion)
+		return suggestion, nil
+	}
+	log.Debugf("No cached suggestion for prompt of document %d", originalDocument.ID)
+
+	// Generate content, asking the LlmClient to correct its answer as long as it does not match the schema of a suggestion
+	// or uses values outside of the vocabulary
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, renderedPrompt)}
+	var callOptions []llms.CallOption
+	if config.StructuredOutput {
+		callOptions = append(callOptions, llms.WithJSONMode())
+	}
+
+	var jsonStr string
+	var modelName string
+	var cacheAnswer bool
+	var suggestion *paperless_model.DocumentSuggestion
+	for attempt := 0; ; attempt++ {
+		completion, err := p.llm.GenerateContent(ctx, messages, callOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("error getting response from LlmClient: %w", err)
+		}
+
+		answer := completion.Choices[0].Content
+		// A fallback may answer the correction of another model's answer, so the model of the last answer is recorded
+		modelName = producingModel(completion, p.modelName)
+		cacheAnswer = cacheable(p, completion)
+		jsonStr = extractJson(answer)
+		log.Infof("Json suggestion for document %d: %s", originalDocument.ID, jsonStr)
+
+		validationErrors := validateSuggestionJson(jsonStr)
+		if len(validationErrors) == 0 {
+			if suggestion, err = unmarshalSuggestion(jsonStr, originalDocument); err != nil {
+				return nil, err
+			}
+
+			validationErrors = vocabulary.violations(*suggestion)
+			if len(validationErrors) == 0 {
+				if attempt > 0 {
+					log.Infof("Suggestion for document %d corrected after %d attempts", originalDocument.ID, attempt+1)
+				}
+				break
+			}
+			if attempt >= config.LlmRepairRetries {
+				// The answer itself is usable, so only the values outside of the vocabulary are dropped
+				removed := vocabulary.enforce(suggestion)
+				log.Warnf("Suggestion for document %d not corrected after %d attempts, removed %s", originalDocument.ID, attempt+1, strings.Join(removed, ", "))
+				break
+			}
+		} else if attempt >= config.LlmRepairRetries {
+			return nil, fmt.Errorf("invalid suggestion after %d attempts: %s", attempt+1, strings.Join(validationErrors, "; "))
+		}
+
+		log.Warnf("Invalid suggestion for document %d, asking for a correction: %s", originalDocument.ID, strings.Join(validationErrors, "; "))
+		messages = append(messages,
+			llms.TextParts(llms.ChatMessageTypeAI, answer),
+			llms.TextParts(llms.ChatMessageTypeHuman, repairPrompt(validationErrors)),
+		)
+	}
+
+	if cacheAnswer {
+		app.cacheLlmAnswer(cacheKey, fmt.Sprintf("suggestion for document %d by %s", originalDocument.ID, modelName), cachedAnswer{Answer: jsonStr, Model: modelName})
+	}
+
+	suggestion.Model = modelName
+	return suggestion, nil
+}
+
+// jsonPromptData returns the data the json template is rendered with
+func jsonPromptData(languageName string, content string, availableTags []string, availableCorrespondents []string, correspondentBlackList []string, tagBlackList []string, availableDocumentTypeNames []string, examples []fewShotExample) map[string]interface{} {
+	return map[string]interface{}{
+		"Language":                 languageName,
 		"AvailableTags":            availableTags,
 		"AvailableCorrespondents":  availableCorrespondents,
 		"BlackList":                correspondentBlackList,
@@ -40,69 +145,14 @@ func (app *App) getSuggestedJson(ctx context.Context, content string, availableT
 		"DocumentTypeExplanation":  config.DocumentTypeExplanation,
 		"CorrespondentExplanation": config.CorrespondentExplanation,
 		"PromptPostamble":          config.PromptPostamble,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("error executing json template: %v", err)
-	}
-
-	prompt := promptBuffer.String()
-	log.Debugf("Json suggestion prompt: %s", prompt)
-
-	// Check cache
-	app.cacheMutex.Lock()
-	if element, found := app.cache[prompt]; found {
-		app.cacheList.MoveToFront(element)
-		app.cacheMutex.Unlock()
-		log.Warnf("Cache hit for prompt of document %d", originalDocument.ID)
-		return unmarshalSuggestion(element.Value.(*CacheEntry).value, originalDocument)
-	} else {
-		log.Debugf("Cache miss for prompt of document %d", originalDocument.ID)
-	}
-	app.cacheMutex.Unlock()
-
-	// Generate content
-	completion, err := app.LlmClient.GenerateContent(ctx, []llms.MessageContent{
-		{
-			Parts: []llms.ContentPart{
-				llms.TextContent{
-					Text: prompt,
-				},
-			},
-			Role: llms.ChatMessageTypeHuman,
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("error getting response from LlmClient: %v", err)
+		"ConfidenceRequested":      config.ConfidenceThreshold != nil,
+		"Examples":                 examples,
 	}
-
-	jsonStr := strings.TrimSpace(completion.Choices[0].Content)
-	log.Infof("Json suggestion for document %d: %s", originalDocument.ID, jsonStr)
-
-	// Store in cache
-	app.cacheMutex.Lock()
-	if app.cacheList.Len() >= maxCacheSize {
-		// Evict the least recently used entry
-		evictElement := app.cacheList.Back()
-		if evictElement != nil {
-			app.cacheList.Remove(evictElement)
-			delete(app.cache, evictElement.Value.(*CacheEntry).key)
-		}
-	}
-	newEntry := &CacheEntry{key: prompt, value: jsonStr}
-	element := app.cacheList.PushFront(newEntry)
-	app.cache[prompt] = element
-	log.Debugf("Added prompt to cache of document %d", originalDocument.ID)
-	app.cacheMutex.Unlock()
-
-	return unmarshalSuggestion(jsonStr, originalDocument)
 }
 
-func unmarshalSuggestion(jsonStr string, originalDocument paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
-
+// extractJson removes text around the json object, e.g. Markdown code fences added by models without structured output
+func extractJson(jsonStr string) string {
 	jsonStr = strings.TrimSpace(jsonStr)
-	if jsonStr == "" {
-		return nil, fmt.Errorf("error: json string is empty or blank")
-	}
 
 	// the json string might begin with invalid characters (Markdown). Remove them until the first '{'
 	for i, c := range jsonStr {
@@ -120,6 +170,16 @@ func unmarshalSuggestion(jsonStr string, originalDocument paperless_model.Docume
 		}
 	}
 
+	return jsonStr
+}
+
+func unmarshalSuggestion(jsonStr string, originalDocument paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
+
+	jsonStr = extractJson(jsonStr)
+	if jsonStr == "" {
+		return nil, fmt.Errorf("error: json string is empty or blank")
+	}
+
 	var suggestion paperless_model.DocumentSuggestion
 	err := json.Unmarshal([]byte(jsonStr), &suggestion)
 	if err != nil {
@@ -135,61 +195,35 @@ func unmarshalSuggestion(jsonStr string, originalDocument paperless_model.Docume
 	return &suggestion, nil
 }
 
-func (app *App) getOcrDocumentSuggestion(ctx context.Context, doc paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
-	var suggestion paperless_model.DocumentSuggestion
-	//
-	//// Fetch all available tags from paperless-ngx
-	//allAvailableTagIDMap, err := app.PaperlessClient.GetAllTags(ctx)
-	//if err != nil {
-	//	return nil, fmt.Errorf("failed to fetch available tags: %v", err)
-	//}
-	//
-	//// create a second map and flip the keys and values
-	//allAvailableTagNameMap := make([]string, 0, len(allAvailableTagIDMap))
-	//for tagName := range allAvailableTagIDMap {
-	//	allAvailableTagNameMap = append(allAvailableTagNameMap, tagName)
-	//}
-	//
-	//var existingDocumentTagNames []string = make([]string, len(doc.Tags))
-	//for i, tagIDString := range doc.Tags {
-	//	tagIDInt, err := strconv.Atoi(tagIDString)
-	//	if err != nil {
-	//		return nil, fmt.Errorf("error converting tagID to int: %v", err)
-	//	}
-	//	existingDocumentTagNames[i] = allAvailableTagNameMap[tagIDInt]
-	//}
-	suggestedTags := append(doc.Tags, config.AutoTag)
-	suggestedTags = paperless_service.RemoveTagFromList(suggestedTags, config.OcrTag)
-	suggestion.Tags = &(suggestedTags)
-
-	// Prepare for generating suggestions
+// extractText downloads the original file of a document and extracts its text with OCR
+func (app *App) extractText(ctx context.Context, doc paperless_model.Document) (string, error) {
 	documentID := doc.ID
-	//content := doc.Content
-	//if len(content) > 5000 {
-	//	content = content[:5000]
-	//}
 
 	docBytes, err := app.PaperlessClient.DownloadPDF(ctx, doc)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading pdf for document %d: %v", documentID, err)
+		return "", fmt.Errorf("error downloading pdf for document %d: %v", documentID, err)
 	}
 
-	// Process the document
-	extractedText, err := ocr.ProcessDocumentOcr(docBytes, doc.ID)
+	// Process the document with the configured OCR provider
+	if app.ocrProvider == nil {
+		return "", fmt.Errorf("no OCR provider is configured")
+	}
+	pages, err := app.ocrProvider.ProcessDocument(ctx, docBytes, http.DetectContentType(docBytes))
 	if err != nil {
-		return nil, fmt.Errorf("error processing document %d: %v", documentID, err)
+		return "", fmt.Errorf("error processing document %d: %w", documentID, err)
 	}
 
-	log.Debugf("Extracted text for document %d: %s", documentID, extractedText)
+	extractedText := ocr.JoinPages(app.ocrProvider, pages)
+	if confidence := ocr.AverageConfidence(pages); confidence != ocr.UnknownConfidence {
+		log.Infof("Recognized %d pages of document %d with an average confidence of %.0f%%", len(pages), documentID, confidence*100)
+	}
 
-	suggestion.DocumentID = documentID
-	suggestion.OriginalDocument = doc
-	suggestion.Content = &extractedText
-	return &suggestion, nil
+	log.Debugf("Extracted text for document %d: %s", documentID, extractedText)
+	return extractedText, nil
 }
 
 // generateAutoDocumentSuggestion generates suggestions (title, tags, and correspondent) for a single document.
-func (app *App) generateAutoDocumentSuggestion(ctx context.Context, doc paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
+func (app *App) generateAutoDocumentSuggestion(ctx context.Context, p *pipeline, doc paperless_model.Document) (*paperless_model.DocumentSuggestion, error) {
 	// Fetch all available tags from paperless-ngx
 	availableTagsMap, err := app.PaperlessClient.GetAllTags(ctx)
 	if err != nil {
@@ -224,29 +258,100 @@ func (app *App) generateAutoDocumentSuggestion(ctx context.Context, doc paperles
 	// Prepare for generating suggestions
 	documentID := doc.ID
 	content := doc.Content
-	if len(content) > 5000 {
-		content = content[:5000]
+
+	// Trigger tags must never be suggested, otherwise documents would be processed again
+	for _, triggerTag := range app.triggerTags() {
+		availableTagNames = paperless_service.RemoveTagFromList(availableTagNames, triggerTag)
+	}
+	if config.ConfidenceThreshold != nil {
+		availableTagNames = paperless_service.RemoveTagFromList(availableTagNames, config.NeedsReviewTag)
 	}
 
-	// Sort the names for consistency (Important for caching)
-	availableTagNames = paperless_service.RemoveTagFromList(availableTagNames, config.OcrTag)
-	availableTagNames = paperless_service.RemoveTagFromList(availableTagNames, config.AutoTag)
+	// Only offer the allowed document types, if they are restricted
+	if len(config.AllowedDocumentTypes) > 0 {
+		availableDocumentTypeNames = append([]string{}, config.AllowedDocumentTypes...)
+	}
 
+	// Sort the names for consistency (Important for caching)
 	sort.Strings(availableTagNames)
 	sort.Strings(availableCorrespondentNames)
 	sort.Strings(availableDocumentTypeNames)
 
+	// Only the most likely names are offered, the full lists are kept for answers which found nothing among them
+	available := candidates{tags: availableTagNames, correspondents: availableCorrespondentNames, documentTypes: availableDocumentTypeNames}
+	selected := app.selectCandidates(ctx, doc, available)
+	prompt := p.languagePrompt(documentID, content)
+
 	// Generate json suggestion
-	if jsonSuggestion, err := app.getSuggestedJson(ctx, content, availableTagNames, availableCorrespondentNames, config.CorrespondentBlackList, config.TagBlackList, availableDocumentTypeNames, doc); err != nil {
-		return nil, fmt.Errorf("error generating json for document %d: %v", documentID, err)
-	} else {
-		for _, tag := range doc.Tags {
-			if tag != config.OcrTag && tag != config.AutoTag {
-				*jsonSuggestion.Tags = append(*jsonSuggestion.Tags, tag)
+	jsonSuggestion, err := app.getSuggestedJson(ctx, p, prompt, content, selected.tags, selected.correspondents, config.CorrespondentBlackList, selected.documentTypes, doc)
+	if err != nil {
+		return nil, fmt.Errorf("error generating json for document %d: %w", documentID, err)
+	}
+	if widened, found := selected.widenUnknown(jsonSuggestion, available); found {
+		log.Infof("No match among the candidates for document %d, asking again with the full lists", documentID)
+		jsonSuggestion, err = app.getSuggestedJson(ctx, p, prompt, content, widened.tags, widened.correspondents, config.CorrespondentBlackList, widened.documentTypes, doc)
+		if err != nil {
+			return nil, fmt.Errorf("error generating json for document %d: %w", documentID, err)
+		}
+	}
+
+	jsonSuggestion.PromptVersion = prompt.version
+	// The language is detected, not taken from the answer of the model
+	jsonSuggestion.Language = nil
+	if prompt.detected != "" {
+		jsonSuggestion.Language = &prompt.detected
+	}
+	for _, tag := range doc.Tags {
+		if tag != p.tagName {
+			*jsonSuggestion.Tags = append(*jsonSuggestion.Tags, tag)
+		}
+	}
+	return jsonSuggestion, nil
+}
+
+// generateDocumentSuggestions generates suggestions for the requested documents without applying them.
+// Fields which were not requested are left empty, so they would not be changed by an update.
+func (app *App) generateDocumentSuggestions(ctx context.Context, request model.GenerateSuggestionsRequest) ([]paperless_model.DocumentSuggestion, error) {
+	p := app.classificationPipeline()
+	if p == nil {
+		return nil, fmt.Errorf("no pipeline is configured to classify documents")
+	}
+
+	suggestions := make([]paperless_model.DocumentSuggestion, 0, len(request.Documents))
+
+	for _, doc := range request.Documents {
+		// Callers may only send the document id, so fetch the document to get its content
+		if strings.TrimSpace(doc.Content) == "" {
+			fetchedDocument, err := app.PaperlessClient.GetDocument(ctx, doc.ID)
+			if err != nil {
+				return nil, fmt.Errorf("error fetching document %d: %w", doc.ID, err)
+			}
+			doc = fetchedDocument
+		}
+
+		suggestion, err := app.generateAutoDocumentSuggestion(ctx, p, doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if !request.GenerateTitles {
+			suggestion.Title = nil
+		}
+		if !request.GenerateTags {
+			existingTags := doc.Tags
+			for _, triggerTag := range app.triggerTags() {
+				existingTags = paperless_service.RemoveTagFromList(existingTags, triggerTag)
 			}
+			suggestion.Tags = &existingTags
+		}
+		if !request.GenerateCorrespondents {
+			suggestion.Correspondent = nil
 		}
-		return jsonSuggestion, nil
+
+		suggestions = append(suggestions, *suggestion)
 	}
+
+	return suggestions, nil
 }
 
 func sortStrings(names []string) []string {
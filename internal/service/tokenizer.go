@@ -0,0 +1,99 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tokenizerEncoding is used to count the tokens of all models. Other models than those of OpenAI use different tokenizers,
+// so their counts are estimates, which is why the budget always keeps a reserve for the answer.
+const tokenizerEncoding = tiktoken.MODEL_CL100K_BASE
+
+var (
+	tokenizer     *tiktoken.Tiktoken
+	tokenizerOnce sync.Once
+)
+
+// countTokens returns the number of tokens of a text. The encoding is embedded in the binary, so counting works
+// without internet access. If it can not be loaded anyway, the number is estimated from the length of the text.
+func countTokens(text string) int {
+	tokenizerOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		encoding, err := tiktoken.GetEncoding(tokenizerEncoding)
+		if err != nil {
+			log.Warnf("Failed to load tokenizer %s, estimating the number of tokens instead: %v", tokenizerEncoding, err)
+			return
+		}
+		tokenizer = encoding
+	})
+
+	if tokenizer == nil {
+		// Most tokens of European languages are longer than three characters, so this rather overestimates
+		return (utf8.RuneCountInString(text) + 2) / 3
+	}
+	return len(tokenizer.EncodeOrdinary(text))
+}
+
+// chunkSeparators are the boundaries at which texts are split, from the most to the least preferred
+var chunkSeparators = []string{"\n\n", "\n", ". ", " ", ""}
+
+// splitText splits a text into chunks of at most maxTokens tokens. Texts are split at paragraphs where possible,
+// then at lines, sentences and words, and only as a last resort between characters.
+func splitText(text string, maxTokens int) []string {
+	var chunks []string
+	for _, chunk := range splitTextAt(text, maxTokens, chunkSeparators) {
+		if chunk = strings.TrimSpace(chunk); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+func splitTextAt(text string, maxTokens int, separators []string) []string {
+	if countTokens(text) <= maxTokens || len(separators) == 0 {
+		return []string{text}
+	}
+
+	separator, remaining := separators[0], separators[1:]
+	for separator != "" && !strings.Contains(text, separator) {
+		separator, remaining = remaining[0], remaining[1:]
+	}
+
+	var parts []string
+	if separator == "" {
+		// Splitting by characters never cuts a multi-byte character in half
+		parts = strings.Split(text, "")
+	} else {
+		parts = strings.SplitAfter(text, separator)
+	}
+
+	var chunks []string
+	var chunk strings.Builder
+	chunkTokens := 0
+	flush := func() {
+		if chunk.Len() > 0 {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+			chunkTokens = 0
+		}
+	}
+	for _, part := range parts {
+		partTokens := countTokens(part)
+		if partTokens > maxTokens {
+			flush()
+			chunks = append(chunks, splitTextAt(part, maxTokens, remaining)...)
+			continue
+		}
+		if chunkTokens+partTokens > maxTokens {
+			flush()
+		}
+		chunk.WriteString(part)
+		chunkTokens += partTokens
+	}
+	flush()
+	return chunks
+}
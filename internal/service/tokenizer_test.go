@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"Rechnung Nr. 12345", 8},
+	}
+
+	for _, test := range tests {
+		if tokens := countTokens(test.text); tokens != test.tokens {
+			t.Errorf("countTokens(%q) = %d, want %d", test.text, tokens, test.tokens)
+		}
+	}
+	if tokenizer == nil {
+		t.Error("the embedded encoding was not loaded, tokens were estimated")
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	paragraph := strings.Repeat("Lorem ipsum dolor sit amet. ", 20)
+
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		chunks    int
+	}{
+		{name: "short text", text: "Rechnung Nr. 12345", maxTokens: 100, chunks: 1},
+		{name: "empty text", text: "  \n\n ", maxTokens: 100, chunks: 0},
+		{name: "paragraphs", text: paragraph + "\n\n" + paragraph + "\n\n" + paragraph, maxTokens: 150, chunks: 3},
+		{name: "sentences", text: paragraph, maxTokens: 50, chunks: 3},
+		{name: "single word", text: strings.Repeat("ä", 300), maxTokens: 20, chunks: 15},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := splitText(test.text, test.maxTokens)
+			if len(chunks) != test.chunks {
+				t.Errorf("got %d chunks, want %d", len(chunks), test.chunks)
+			}
+			for i, chunk := range chunks {
+				if tokens := countTokens(chunk); tokens > test.maxTokens {
+					t.Errorf("chunk %d has %d tokens, more than %d", i, tokens, test.maxTokens)
+				}
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %d cuts a character in half", i)
+				}
+			}
+			if joined, original := strings.Join(strings.Fields(strings.Join(chunks, "")), ""), strings.Join(strings.Fields(test.text), ""); joined != original {
+				t.Errorf("chunks do not contain the text: %q", chunks)
+			}
+		})
+	}
+}
+
+func TestSplitTextPrefersParagraphs(t *testing.T) {
+	text := "First paragraph.\nSecond line.\n\nSecond paragraph."
+	chunks := splitText(text, countTokens("First paragraph.\nSecond line."))
+
+	want := []string{"First paragraph.\nSecond line.", "Second paragraph."}
+	if len(chunks) != len(want) || chunks[0] != want[0] || chunks[1] != want[1] {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+}
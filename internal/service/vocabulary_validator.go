@@ -0,0 +1,184 @@
+package service
+
+import (
+	"fmt"
+	"paperless-gpt/internal/config"
+	"paperless-gpt/paperless/paperless_model"
+	"strings"
+	"unicode"
+)
+
+// vocabulary is the set of values a suggestion may use. Answers of the LLM are checked against it after generation,
+// since the prompt alone does not keep models from inventing tags or using blacklisted correspondents.
+type vocabulary struct {
+	tags                   []string
+	correspondentBlackList []string
+	documentTypes          []string
+	maxTags                int
+}
+
+// newVocabulary creates a vocabulary of the available tags. Document types are only restricted by ALLOWED_DOCUMENT_TYPES.
+func newVocabulary(availableTags []string, correspondentBlackList []string) vocabulary {
+	return vocabulary{
+		tags:                   availableTags,
+		correspondentBlackList: correspondentBlackList,
+		documentTypes:          config.AllowedDocumentTypes,
+		maxTags:                config.MaxSuggestedTags,
+	}
+}
+
+// violations returns a description of every value of the suggestion outside of the vocabulary, with hints for the LLM how to correct it
+func (v vocabulary) violations(suggestion paperless_model.DocumentSuggestion) []string {
+	var violations []string
+
+	if suggestion.Tags != nil {
+		for _, tag := range *suggestion.Tags {
+			if containsString(v.tags, tag) {
+				continue
+			}
+			if match, found := nearestMatch(tag, v.tags); found {
+				violations = append(violations, fmt.Sprintf("the tag %q does not exist, use the exact spelling %q", tag, match))
+			} else {
+				violations = append(violations, fmt.Sprintf("the tag %q does not exist, only use tags from the list of available tags", tag))
+			}
+		}
+		if v.maxTags > 0 && len(*suggestion.Tags) > v.maxTags {
+			violations = append(violations, fmt.Sprintf("%d tags were chosen, choose at most %d tags", len(*suggestion.Tags), v.maxTags))
+		}
+	}
+
+	if suggestion.Correspondent != nil {
+		if blacklisted, found := v.blacklistedCorrespondent(*suggestion.Correspondent); found {
+			violations = append(violations, fmt.Sprintf("the correspondent %q is blacklisted (%q), choose another correspondent", *suggestion.Correspondent, blacklisted))
+		}
+	}
+
+	if suggestion.DocumentType != nil && len(v.documentTypes) > 0 && !containsString(v.documentTypes, *suggestion.DocumentType) {
+		if match, found := nearestMatch(*suggestion.DocumentType, v.documentTypes); found {
+			violations = append(violations, fmt.Sprintf("the document type %q is not allowed, use the exact spelling %q", *suggestion.DocumentType, match))
+		} else {
+			violations = append(violations, fmt.Sprintf("the document type %q is not allowed, choose one of: %s", *suggestion.DocumentType, strings.Join(v.documentTypes, ", ")))
+		}
+	}
+
+	return violations
+}
+
+// enforce removes every value of the suggestion outside of the vocabulary, used once the LLM did not manage to correct them.
+// It returns a description of the removed values.
+func (v vocabulary) enforce(suggestion *paperless_model.DocumentSuggestion) []string {
+	var removed []string
+
+	if suggestion.Tags != nil {
+		tags := []string{}
+		for _, tag := range *suggestion.Tags {
+			switch {
+			case !containsString(v.tags, tag):
+				removed = append(removed, fmt.Sprintf("unknown tag %q", tag))
+			case v.maxTags > 0 && len(tags) >= v.maxTags:
+				removed = append(removed, fmt.Sprintf("tag %q above the limit of %d tags", tag, v.maxTags))
+			default:
+				tags = append(tags, tag)
+			}
+		}
+		suggestion.Tags = &tags
+	}
+
+	if suggestion.Correspondent != nil {
+		if _, found := v.blacklistedCorrespondent(*suggestion.Correspondent); found {
+			removed = append(removed, fmt.Sprintf("blacklisted correspondent %q", *suggestion.Correspondent))
+			suggestion.Correspondent = nil
+		}
+	}
+
+	if suggestion.DocumentType != nil && len(v.documentTypes) > 0 && !containsString(v.documentTypes, *suggestion.DocumentType) {
+		removed = append(removed, fmt.Sprintf("document type %q", *suggestion.DocumentType))
+		suggestion.DocumentType = nil
+	}
+
+	return removed
+}
+
+// blacklistedCorrespondent returns the entry of the black list the correspondent matches.
+// Variations like "Amazon EU" for a blacklisted "Amazon" match as well.
+func (v vocabulary) blacklistedCorrespondent(correspondent string) (string, bool) {
+	normalizedCorrespondent := normalizeName(correspondent)
+	if normalizedCorrespondent == "" {
+		return "", false
+	}
+	for _, blacklisted := range v.correspondentBlackList {
+		normalizedBlacklisted := normalizeName(blacklisted)
+		if normalizedBlacklisted != "" && strings.Contains(normalizedCorrespondent, normalizedBlacklisted) {
+			return blacklisted, true
+		}
+	}
+	return "", false
+}
+
+// nearestMatch finds the name which is most likely meant by the given value, e.g. "Rechnungen & Belege" for "rechnungen und belege"
+func nearestMatch(value string, names []string) (string, bool) {
+	normalizedValue := normalizeName(value)
+	bestMatch := ""
+	bestDistance := len(normalizedValue)/4 + 1
+
+	for _, name := range names {
+		distance := levenshtein(normalizedValue, normalizeName(name))
+		if distance < bestDistance {
+			bestMatch = name
+			bestDistance = distance
+		}
+	}
+
+	return bestMatch, bestMatch != ""
+}
+
+// normalizeName lowercases a name and removes everything except letters and digits.
+// Conjunctions are removed as well, since models often write out "&".
+func normalizeName(name string) string {
+	var normalized strings.Builder
+	for _, word := range strings.Fields(strings.ToLower(name)) {
+		if word == "und" || word == "and" || word == "&" {
+			continue
+		}
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				normalized.WriteRune(r)
+			}
+		}
+	}
+	return normalized.String()
+}
+
+// levenshtein returns the number of single character edits needed to turn a into b
+func levenshtein(a string, b string) int {
+	aRunes, bRunes := []rune(a), []rune(b)
+	previous := make([]int, len(bRunes)+1)
+	current := make([]int, len(bRunes)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(aRunes); i++ {
+		current[0] = i
+		for j := 1; j <= len(bRunes); j++ {
+			cost := 1
+			if aRunes[i-1] == bRunes[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(bRunes)]
+}
+
+// containsString reports whether the list contains the value
+func containsString(list []string, value string) bool {
+	for _, element := range list {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
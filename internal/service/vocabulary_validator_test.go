@@ -0,0 +1,172 @@
+package service
+
+import (
+	"paperless-gpt/paperless/paperless_model"
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"rechnung", "rechnung", 0},
+		{"rechnung", "rechnungen", 2},
+		{"kitten", "sitting", 3},
+		{"grüße", "grusse", 3},
+		{"ü", "u", 1},
+	}
+
+	for _, test := range tests {
+		if distance := levenshtein(test.a, test.b); distance != test.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, distance, test.distance)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name       string
+		normalized string
+	}{
+		{"Rechnungen & Belege", "rechnungenbelege"},
+		{"rechnungen und belege", "rechnungenbelege"},
+		{"Bills and Receipts", "billsreceipts"},
+		{"Amazon EU S.a.r.l.", "amazoneusarl"},
+		{"  Steuer-2024 ", "steuer2024"},
+		{"&", ""},
+	}
+
+	for _, test := range tests {
+		if normalized := normalizeName(test.name); normalized != test.normalized {
+			t.Errorf("normalizeName(%q) = %q, want %q", test.name, normalized, test.normalized)
+		}
+	}
+}
+
+func TestNearestMatch(t *testing.T) {
+	names := []string{"Rechnungen & Belege", "Versicherung & Vorsorge", "Steuer"}
+
+	tests := []struct {
+		value string
+		match string
+		found bool
+	}{
+		{"rechnungen und belege", "Rechnungen & Belege", true},
+		{"Versicherungen & Vorsorge", "Versicherung & Vorsorge", true},
+		{"steuern", "Steuer", true},
+		{"Urlaub", "", false},
+		{"Stadt", "", false},
+	}
+
+	for _, test := range tests {
+		match, found := nearestMatch(test.value, names)
+		if match != test.match || found != test.found {
+			t.Errorf("nearestMatch(%q) = %q, %t, want %q, %t", test.value, match, found, test.match, test.found)
+		}
+	}
+}
+
+func TestVocabularyViolations(t *testing.T) {
+	v := vocabulary{
+		tags:                   []string{"Rechnungen & Belege", "Steuer", "Versicherung"},
+		correspondentBlackList: []string{"Amazon"},
+		documentTypes:          []string{"Rechnung", "Vertrag"},
+		maxTags:                2,
+	}
+
+	tests := []struct {
+		name       string
+		suggestion paperless_model.DocumentSuggestion
+		violations []string
+	}{
+		{
+			name: "valid",
+			suggestion: paperless_model.DocumentSuggestion{
+				Tags: &[]string{"Steuer"}, Correspondent: stringPointer("Stadtwerke"), DocumentType: stringPointer("Rechnung"),
+			},
+		},
+		{
+			name:       "misspelled tag",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{"rechnungen und belege"}},
+			violations: []string{`the tag "rechnungen und belege" does not exist, use the exact spelling "Rechnungen & Belege"`},
+		},
+		{
+			name:       "unknown tag",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{"Urlaub"}},
+			violations: []string{`the tag "Urlaub" does not exist, only use tags from the list of available tags`},
+		},
+		{
+			name:       "too many tags",
+			suggestion: paperless_model.DocumentSuggestion{Tags: &[]string{"Steuer", "Versicherung", "Rechnungen & Belege"}},
+			violations: []string{"3 tags were chosen, choose at most 2 tags"},
+		},
+		{
+			name:       "blacklisted correspondent",
+			suggestion: paperless_model.DocumentSuggestion{Correspondent: stringPointer("Amazon EU S.a.r.l.")},
+			violations: []string{`the correspondent "Amazon EU S.a.r.l." is blacklisted ("Amazon"), choose another correspondent`},
+		},
+		{
+			name:       "misspelled document type",
+			suggestion: paperless_model.DocumentSuggestion{DocumentType: stringPointer("Rechnungen")},
+			violations: []string{`the document type "Rechnungen" is not allowed, use the exact spelling "Rechnung"`},
+		},
+		{
+			name:       "document type not allowed",
+			suggestion: paperless_model.DocumentSuggestion{DocumentType: stringPointer("Brief")},
+			violations: []string{`the document type "Brief" is not allowed, choose one of: Rechnung, Vertrag`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if violations := v.violations(test.suggestion); !reflect.DeepEqual(violations, test.violations) {
+				t.Errorf("violations = %q, want %q", violations, test.violations)
+			}
+		})
+	}
+}
+
+func TestVocabularyEnforce(t *testing.T) {
+	v := vocabulary{
+		tags:                   []string{"Steuer", "Versicherung", "Rechnungen & Belege"},
+		correspondentBlackList: []string{"Amazon"},
+		documentTypes:          []string{"Rechnung"},
+		maxTags:                2,
+	}
+	suggestion := paperless_model.DocumentSuggestion{
+		Tags:          &[]string{"Urlaub", "Steuer", "Versicherung", "Rechnungen & Belege"},
+		Correspondent: stringPointer("amazon"),
+		DocumentType:  stringPointer("Brief"),
+		Title:         stringPointer("Rechnung 12345"),
+	}
+
+	removed := v.enforce(&suggestion)
+
+	wantRemoved := []string{
+		`unknown tag "Urlaub"`,
+		`tag "Rechnungen & Belege" above the limit of 2 tags`,
+		`blacklisted correspondent "amazon"`,
+		`document type "Brief"`,
+	}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("removed = %q, want %q", removed, wantRemoved)
+	}
+	if !reflect.DeepEqual(*suggestion.Tags, []string{"Steuer", "Versicherung"}) {
+		t.Errorf("tags = %q", *suggestion.Tags)
+	}
+	if suggestion.Correspondent != nil || suggestion.DocumentType != nil {
+		t.Errorf("correspondent %v and document type %v were not removed", suggestion.Correspondent, suggestion.DocumentType)
+	}
+	if *suggestion.Title != "Rechnung 12345" {
+		t.Errorf("title changed to %q", *suggestion.Title)
+	}
+}
+
+func stringPointer(value string) *string {
+	return &value
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// errQueueFull is returned when a pipeline can not take any more documents from webhooks
+var errQueueFull = errors.New("queue is full, the document will be picked up by polling")
+
+// documentURLPattern matches the document id in urls like http://paperless/documents/42/details
+var documentURLPattern = regexp.MustCompile(`/documents/(\d+)`)
+
+// webhookPayload is the body a paperless-ngx workflow webhook action sends
+type webhookPayload struct {
+	DocumentID  json.Number `json:"document_id"`
+	DocumentURL string      `json:"doc_url"`
+}
+
+// enqueueDocument fetches a document and hands it to every pipeline it is triggered for.
+// It returns the names of these pipelines.
+func (app *App) enqueueDocument(ctx context.Context, documentID int) ([]string, error) {
+	document, err := app.PaperlessClient.GetDocument(ctx, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching document %d: %w", documentID, err)
+	}
+
+	pipelineNames := []string{}
+	for _, p := range app.pipelines {
+		if !p.isTriggeredBy(document) {
+			continue
+		}
+		if !p.enqueue(documentID) {
+			return pipelineNames, fmt.Errorf("pipeline %s: %w", p.name, errQueueFull)
+		}
+		log.Infof("Document %d enqueued for pipeline %s", documentID, p.name)
+		pipelineNames = append(pipelineNames, p.name)
+	}
+
+	if len(pipelineNames) == 0 {
+		log.Debugf("Document %d does not carry any trigger tag, ignoring webhook.", documentID)
+	}
+	return pipelineNames, nil
+}
+
+// documentIDFromWebhook reads the document id from the query, a form or a json body.
+// Besides a plain document_id, the doc_url placeholder of paperless-ngx workflows is understood.
+func documentIDFromWebhook(r *http.Request) (int, error) {
+	payload := webhookPayload{
+		DocumentID:  json.Number(r.URL.Query().Get("document_id")),
+		DocumentURL: r.URL.Query().Get("doc_url"),
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("invalid webhook payload: %w", err)
+		}
+	} else if err := r.ParseForm(); err == nil {
+		if value := r.PostForm.Get("document_id"); value != "" {
+			payload.DocumentID = json.Number(value)
+		}
+		if value := r.PostForm.Get("doc_url"); value != "" {
+			payload.DocumentURL = value
+		}
+	}
+
+	if payload.DocumentID != "" {
+		documentID, err := strconv.Atoi(payload.DocumentID.String())
+		if err != nil {
+			return 0, fmt.Errorf("invalid document_id: %q", payload.DocumentID)
+		}
+		return documentID, nil
+	}
+
+	if matches := documentURLPattern.FindStringSubmatch(payload.DocumentURL); matches != nil {
+		return strconv.Atoi(matches[1])
+	}
+
+	return 0, fmt.Errorf("webhook payload contains neither document_id nor doc_url")
+}
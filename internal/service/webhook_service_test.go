@@ -0,0 +1,87 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"paperless-gpt/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestDocumentIDFromWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		documentID  int
+		invalid     bool
+	}{
+		{name: "query", query: "?document_id=42", documentID: 42},
+		{name: "query url", query: "?doc_url=http://paperless/documents/42/details", documentID: 42},
+		{name: "json id", contentType: "application/json", body: `{"document_id": 42}`, documentID: 42},
+		{name: "json string id", contentType: "application/json; charset=utf-8", body: `{"document_id": "42"}`, documentID: 42},
+		{name: "json url", contentType: "application/json", body: `{"doc_url": "https://paperless.example.com/documents/7/"}`, documentID: 7},
+		{name: "json overrides query", query: "?document_id=1", contentType: "application/json", body: `{"document_id": 42}`, documentID: 42},
+		{name: "empty json body", query: "?document_id=42", contentType: "application/json", documentID: 42},
+		{name: "form id", contentType: "application/x-www-form-urlencoded", body: "document_id=42", documentID: 42},
+		{name: "form url", contentType: "application/x-www-form-urlencoded", body: "doc_url=http%3A%2F%2Fpaperless%2Fdocuments%2F42%2F", documentID: 42},
+		{name: "invalid json", contentType: "application/json", body: `{"document_id":`, invalid: true},
+		{name: "invalid id", query: "?document_id=abc", invalid: true},
+		{name: "url without id", query: "?doc_url=http://paperless/documents/", invalid: true},
+		{name: "nothing", invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/webhook"+test.query, strings.NewReader(test.body))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+
+			documentID, err := documentIDFromWebhook(r)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("got document %d, want an error", documentID)
+				}
+				return
+			}
+			if err != nil || documentID != test.documentID {
+				t.Errorf("documentIDFromWebhook() = %d, %v, want %d", documentID, err, test.documentID)
+			}
+		})
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	previous := config.WebhookSecret
+	config.WebhookSecret = "secret"
+	defer func() { config.WebhookSecret = previous }()
+
+	tests := []struct {
+		name   string
+		secret string
+		status int
+	}{
+		{name: "missing secret", status: http.StatusUnauthorized},
+		{name: "wrong secret", secret: "guess", status: http.StatusUnauthorized},
+		{name: "prefix of secret", secret: "sec", status: http.StatusUnauthorized},
+		// The secret is accepted, the request fails afterwards for lack of a document id
+		{name: "valid secret", secret: "secret", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/webhook", nil)
+			if test.secret != "" {
+				r.Header.Set("X-Webhook-Secret", test.secret)
+			}
+			w := httptest.NewRecorder()
+			(&App{}).webhookHandler(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d", w.Code, test.status)
+			}
+		})
+	}
+}
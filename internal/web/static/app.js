@@ -0,0 +1,244 @@
+"use strict";
+
+const suggestionsElement = document.getElementById("suggestions");
+const statusElement = document.getElementById("status");
+const template = document.getElementById("suggestion-template");
+
+// cards maps document ids to their rendered elements and edited values
+const cards = new Map();
+
+// apiTokenKey is the key of the API_TOKEN in the local storage of the browser
+const apiTokenKey = "paperless-gpt-api-token";
+
+// authorizedFetch sends a request with the API_TOKEN, which is asked for once if the server requires it and kept for
+// later visits
+async function authorizedFetch(method, path, options = {}, retried = false) {
+    const token = localStorage.getItem(apiTokenKey);
+    const headers = {...options.headers};
+    if (token) {
+        headers["X-API-Token"] = token;
+    }
+    const response = await fetch(path, {...options, method, headers});
+    if (response.status === 401 && !retried) {
+        // Concurrent requests only ask once, the others retry with the token entered meanwhile
+        if (localStorage.getItem(apiTokenKey) === token) {
+            const entered = window.prompt("API token of paperless-gpt (API_TOKEN)");
+            if (!entered) {
+                return response;
+            }
+            localStorage.setItem(apiTokenKey, entered);
+        }
+        return authorizedFetch(method, path, options, true);
+    }
+    return response;
+}
+
+async function request(method, path, body) {
+    const options = {};
+    if (body !== undefined) {
+        options.headers = {"Content-Type": "application/json"};
+        options.body = JSON.stringify(body);
+    }
+    const response = await authorizedFetch(method, path, options);
+    const payload = await response.json();
+    if (!response.ok) {
+        throw new Error(payload.error || response.statusText);
+    }
+    return payload;
+}
+
+// loadThumbnail fetches a thumbnail with the API_TOKEN, which an img element cannot send, and shows it as blob URL
+async function loadThumbnail(image, documentID) {
+    try {
+        const response = await authorizedFetch("GET", `api/documents/${documentID}/thumbnail`);
+        if (!response.ok) {
+            return;
+        }
+        const url = URL.createObjectURL(await response.blob());
+        image.addEventListener("load", () => URL.revokeObjectURL(url), {once: true});
+        image.src = url;
+    } catch (error) {
+        // The card stays usable without preview
+    }
+}
+
+function setStatus(message) {
+    statusElement.textContent = message;
+}
+
+async function loadOptions(path, datalistId) {
+    const names = await request("GET", path);
+    const datalist = document.getElementById(datalistId);
+    datalist.replaceChildren(...names.map((name) => {
+        const option = document.createElement("option");
+        option.value = name;
+        return option;
+    }));
+}
+
+function renderTags(card) {
+    card.tagsElement.replaceChildren(...card.tags.map((tag) => {
+        const chip = document.createElement("span");
+        chip.className = "tag";
+        chip.textContent = tag;
+        chip.title = "Remove tag";
+        chip.addEventListener("click", () => {
+            card.tags = card.tags.filter((existing) => existing !== tag);
+            markDirty(card);
+            renderTags(card);
+        });
+        return chip;
+    }));
+}
+
+function markDirty(card) {
+    card.dirty = true;
+    card.element.classList.add("dirty");
+}
+
+function renderSuggestion(pending) {
+    const suggestion = pending.suggestion;
+    const original = suggestion.original_document;
+    const element = template.content.firstElementChild.cloneNode(true);
+    const field = (selector) => element.querySelector(selector);
+
+    const card = {
+        id: suggestion.id,
+        element,
+        dirty: false,
+        tags: [...(suggestion.tags || [])],
+        tagsElement: field(".tags"),
+    };
+
+    field(".document-id").textContent = `#${suggestion.id}`;
+    loadThumbnail(field(".thumbnail"), suggestion.id);
+
+    field(".current-title").textContent = original.title || "";
+    field(".current-correspondent").textContent = original.correspondent || "";
+    field(".current-document-type").textContent = original.document_type || "";
+    field(".current-created-date").textContent = original.created_date || "";
+    field(".current-tags").textContent = (original.tags || []).join(", ");
+
+    field(".title").value = suggestion.title || "";
+    field(".correspondent").value = suggestion.correspondent || "";
+    field(".document-type").value = suggestion.document_type || "";
+    field(".created-date").value = suggestion.created_date || "";
+
+    for (const input of element.querySelectorAll("td input")) {
+        input.addEventListener("input", () => markDirty(card));
+    }
+
+    const tagInput = field(".tag-input");
+    tagInput.addEventListener("keydown", (event) => {
+        if (event.key !== "Enter") {
+            return;
+        }
+        event.preventDefault();
+        const tag = tagInput.value.trim();
+        if (tag !== "" && !card.tags.includes(tag)) {
+            card.tags.push(tag);
+            renderTags(card);
+        }
+        tagInput.value = "";
+    });
+
+    field(".save").addEventListener("click", () => run(() => save(card), `Saved document ${card.id}`));
+    field(".approve").addEventListener("click", () => run(() => approve([card]), `Approved document ${card.id}`));
+    field(".reject").addEventListener("click", () => run(() => reject([card]), `Rejected document ${card.id}`));
+
+    renderTags(card);
+    cards.set(card.id, card);
+    return element;
+}
+
+async function save(card) {
+    const field = (selector) => card.element.querySelector(selector);
+    await request("PATCH", `api/suggestions/${card.id}`, {
+        title: field(".title").value,
+        correspondent: field(".correspondent").value,
+        document_type: field(".document-type").value,
+        created_date: field(".created-date").value,
+        tags: card.tags,
+    });
+    card.dirty = false;
+    card.element.classList.remove("dirty");
+}
+
+async function bulk(action, selectedCards) {
+    const results = await request("POST", `api/suggestions/${action}`, {ids: selectedCards.map((card) => card.id)});
+    const failed = results.filter((result) => result.error);
+    for (const result of results) {
+        if (!result.error) {
+            cards.get(result.id).element.remove();
+            cards.delete(result.id);
+        }
+    }
+    if (failed.length > 0) {
+        throw new Error(failed.map((result) => `#${result.id}: ${result.error}`).join("; "));
+    }
+}
+
+async function approve(selectedCards) {
+    // Edits must be stored before approving, otherwise the unedited suggestion would be applied
+    for (const card of selectedCards) {
+        if (card.dirty) {
+            await save(card);
+        }
+    }
+    await bulk("approve", selectedCards);
+}
+
+async function reject(selectedCards) {
+    await bulk("reject", selectedCards);
+}
+
+function selectedCards() {
+    return [...cards.values()].filter((card) => card.element.querySelector(".select").checked);
+}
+
+async function run(action, successMessage) {
+    setStatus("Working…");
+    try {
+        await action();
+        if (successMessage !== undefined) {
+            setStatus(successMessage);
+        }
+    } catch (error) {
+        setStatus(`Error: ${error.message}`);
+    }
+}
+
+async function loadSuggestions() {
+    const pendingSuggestions = await request("GET", "api/suggestions");
+    cards.clear();
+    suggestionsElement.replaceChildren(...pendingSuggestions.map(renderSuggestion));
+    document.getElementById("select-all").checked = false;
+    setStatus(pendingSuggestions.length === 0 ? "No suggestions waiting for review." : `${pendingSuggestions.length} suggestions waiting for review.`);
+}
+
+document.getElementById("select-all").addEventListener("change", (event) => {
+    for (const card of cards.values()) {
+        card.element.querySelector(".select").checked = event.target.checked;
+    }
+});
+
+document.getElementById("approve-selected").addEventListener("click", () => {
+    const selected = selectedCards();
+    run(() => approve(selected), `Approved ${selected.length} documents`);
+});
+
+document.getElementById("reject-selected").addEventListener("click", () => {
+    const selected = selectedCards();
+    run(() => reject(selected), `Rejected ${selected.length} documents`);
+});
+
+document.getElementById("refresh").addEventListener("click", () => run(loadSuggestions));
+
+run(async () => {
+    await Promise.all([
+        loadOptions("api/tags", "tag-options"),
+        loadOptions("api/correspondents", "correspondent-options"),
+        loadOptions("api/document-types", "document-type-options"),
+    ]);
+    await loadSuggestions();
+});
@@ -0,0 +1,20 @@
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// Handler serves the embedded review front end
+func Handler() http.Handler {
+	staticRoot, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		// The embedded directory is fixed at compile time, so this can only be a programming error
+		panic(err)
+	}
+	return http.FileServer(http.FS(staticRoot))
+}
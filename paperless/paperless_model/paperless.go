@@ -63,6 +63,41 @@ type DocumentSuggestion struct {
 	Tags             *[]string `json:"tags"`
 	DocumentType     *string   `json:"document_type,omitempty"`
 	Content          *string   `json:"content,omitempty"`
+	Summary          *string   `json:"summary,omitempty"`
+	Model            string    `json:"model,omitempty"`
+	PromptVersion    string    `json:"prompt_version,omitempty"`
+	// Language is the detected language of the document, only set with DETECT_LANGUAGE
+	Language *string `json:"language,omitempty"`
+	// Confidence is how sure the LLM is about each generated field, only requested if CONFIDENCE_THRESHOLD is set
+	Confidence *FieldConfidence `json:"confidence,omitempty"`
+}
+
+// FieldConfidence holds a confidence between 0 and 1 for every field generated by the LLM
+type FieldConfidence struct {
+	Title         float64 `json:"title"`
+	Correspondent float64 `json:"correspondent"`
+	DocumentType  float64 `json:"document_type"`
+	CreatedDate   float64 `json:"created_date"`
+	Tags          float64 `json:"tags"`
+}
+
+const (
+	EntityTypeCorrespondent = "correspondent"
+	EntityTypeDocumentType  = "document_type"
+)
+
+// DocumentCounts are the numbers of documents of every tag, correspondent and document type by name
+type DocumentCounts struct {
+	Tags           map[string]int
+	Correspondents map[string]int
+	DocumentTypes  map[string]int
+}
+
+// CreatedEntity is a correspondent or document type which was created while applying a suggestion
+type CreatedEntity struct {
+	Type string `json:"type"`
+	ID   *int   `json:"id,omitempty"`
+	Name string `json:"name"`
 }
 
 type Correspondent struct {
@@ -93,47 +128,25 @@ type Document struct {
 	Title            string        `json:"title"`
 	Content          string        `json:"content"`
 	Tags             []string      `json:"tags"`
+	Correspondent    string        `json:"correspondent,omitempty"`
+	DocumentType     string        `json:"document_type,omitempty"`
+	CreatedDate      string        `json:"created_date,omitempty"`
 	OriginalFileName string        `json:"original_file_name"`
 	CustomFields     []CustomField `json:"custom_fields"`
 }
 
 type GetDocumentsApiResponse struct {
-	Count    int         `json:"count"`
-	Next     interface{} `json:"next"`
-	Previous interface{} `json:"previous"`
-	All      []int       `json:"all"`
-	Results  []struct {
-		ID                  int           `json:"id"`
-		Correspondent       interface{}   `json:"correspondent"`
-		DocumentType        interface{}   `json:"document_type"`
-		StoragePath         interface{}   `json:"storage_path"`
-		Title               string        `json:"title"`
-		Content             string        `json:"content"`
-		Tags                []int         `json:"tags"`
-		Created             FlexibleTime  `json:"created"`
-		CreatedDate         string        `json:"created_date"`
-		Modified            FlexibleTime  `json:"modified"`
-		Added               FlexibleTime  `json:"added"`
-		ArchiveSerialNumber interface{}   `json:"archive_serial_number"`
-		OriginalFileName    string        `json:"original_file_name"`
-		ArchivedFileName    string        `json:"archived_file_name"`
-		Owner               int           `json:"owner"`
-		UserCanChange       bool          `json:"user_can_change"`
-		Notes               []interface{} `json:"notes"`
-		CustomFields        []CustomField `json:"custom_fields"`
-		SearchHit           struct {
-			Score          float64 `json:"score"`
-			Highlights     string  `json:"highlights"`
-			NoteHighlights string  `json:"note_highlights"`
-			Rank           int     `json:"rank"`
-		} `json:"__search_hit__"`
-	} `json:"results"`
+	Count    int                      `json:"count"`
+	Next     interface{}              `json:"next"`
+	Previous interface{}              `json:"previous"`
+	All      []int                    `json:"all"`
+	Results  []GetDocumentApiResponse `json:"results"`
 }
 
 type GetDocumentApiResponse struct {
 	ID                  int           `json:"id"`
-	Correspondent       interface{}   `json:"correspondent"`
-	DocumentType        interface{}   `json:"document_type"`
+	Correspondent       *int          `json:"correspondent"`
+	DocumentType        *int          `json:"document_type"`
 	StoragePath         interface{}   `json:"storage_path"`
 	Title               string        `json:"title"`
 	Content             string        `json:"content"`
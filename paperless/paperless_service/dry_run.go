@@ -0,0 +1,141 @@
+package paperless_service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"paperless-gpt/paperless/paperless_model"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DocumentDiff describes the changes which would be applied to a document in dry-run mode
+type DocumentDiff struct {
+	Timestamp   time.Time                       `json:"timestamp"`
+	DocumentID  int                             `json:"document_id"`
+	Before      map[string]interface{}          `json:"before,omitempty"`
+	After       map[string]interface{}          `json:"after,omitempty"`
+	Patch       map[string]interface{}          `json:"patch,omitempty"`
+	WouldCreate []paperless_model.CreatedEntity `json:"would_create,omitempty"`
+	// Notes are the notes which would be added to the document
+	Notes []string `json:"notes,omitempty"`
+}
+
+// newSuggestionDiff compares the original document of a suggestion with the suggested values
+func newSuggestionDiff(suggestion paperless_model.DocumentSuggestion, patch map[string]interface{}, wouldCreate []paperless_model.CreatedEntity) DocumentDiff {
+	original := suggestion.OriginalDocument
+	before := map[string]interface{}{}
+	after := map[string]interface{}{}
+
+	if suggestion.Title != nil {
+		before["title"] = original.Title
+		after["title"] = patch["title"]
+	}
+	if suggestion.Tags != nil {
+		before["tags"] = original.Tags
+		after["tags"] = *suggestion.Tags
+	}
+	if suggestion.Correspondent != nil {
+		before["correspondent"] = original.Correspondent
+		after["correspondent"] = *suggestion.Correspondent
+	}
+	if suggestion.DocumentType != nil {
+		before["document_type"] = original.DocumentType
+		after["document_type"] = *suggestion.DocumentType
+	}
+	if _, found := patch["created_date"]; found {
+		before["created_date"] = original.CreatedDate
+		after["created_date"] = *suggestion.Date
+	}
+	if suggestion.Content != nil {
+		before["content"] = original.Content
+		after["content"] = *suggestion.Content
+	}
+	var notes []string
+	if suggestion.Summary != nil && strings.TrimSpace(*suggestion.Summary) != "" {
+		notes = append(notes, *suggestion.Summary)
+	}
+
+	return DocumentDiff{
+		Timestamp:   time.Now(),
+		DocumentID:  suggestion.DocumentID,
+		Before:      before,
+		After:       after,
+		Patch:       patch,
+		WouldCreate: wouldCreate,
+		Notes:       notes,
+	}
+}
+
+// newTagsDiff describes a change of the tags of a document
+func newTagsDiff(documentID int, tags []string, patch map[string]interface{}) DocumentDiff {
+	return DocumentDiff{
+		Timestamp:  time.Now(),
+		DocumentID: documentID,
+		After:      map[string]interface{}{"tags": tags},
+		Patch:      patch,
+	}
+}
+
+// newNoteDiff describes a note added to a document
+func newNoteDiff(documentID int, note string) DocumentDiff {
+	return DocumentDiff{
+		Timestamp:  time.Now(),
+		DocumentID: documentID,
+		Notes:      []string{note},
+	}
+}
+
+// newCreatedEntity describes a created correspondent or document type. The id is nil in dry-run mode.
+func newCreatedEntity(entityType string, name string, id *int) paperless_model.CreatedEntity {
+	return paperless_model.CreatedEntity{
+		Type: entityType,
+		ID:   id,
+		Name: name,
+	}
+}
+
+// reportDryRun logs the diff and appends it as json line to the dry-run report
+func (paperlessClient *PaperlessClient) reportDryRun(diff DocumentDiff) error {
+	log.WithFields(logrus.Fields{
+		"document_id":  diff.DocumentID,
+		"before":       diff.Before,
+		"after":        diff.After,
+		"patch":        diff.Patch,
+		"would_create": diff.WouldCreate,
+		"notes":        diff.Notes,
+	}).Infof("Dry run: document %d would be updated", diff.DocumentID)
+
+	if paperlessClient.DryRunReportPath == "" {
+		return nil
+	}
+
+	var line bytes.Buffer
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(diff); err != nil {
+		return fmt.Errorf("error marshalling dry-run diff of document %d: %w", diff.DocumentID, err)
+	}
+
+	paperlessClient.reportMutex.Lock()
+	defer paperlessClient.reportMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(paperlessClient.DryRunReportPath), os.ModePerm); err != nil {
+		return fmt.Errorf("error creating directory for dry-run report: %w", err)
+	}
+
+	reportFile, err := os.OpenFile(paperlessClient.DryRunReportPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening dry-run report: %w", err)
+	}
+	defer reportFile.Close()
+
+	if _, err := reportFile.Write(line.Bytes()); err != nil {
+		return fmt.Errorf("error writing dry-run report: %w", err)
+	}
+	return nil
+}
@@ -8,9 +8,13 @@ import (
 	"io"
 	"net/http"
 	"paperless-gpt/internal/config"
+	"paperless-gpt/internal/journal"
+	"paperless-gpt/internal/limiter"
 	"paperless-gpt/internal/logging"
 	"paperless-gpt/paperless/paperless_model"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,18 +27,67 @@ type PaperlessClient struct {
 	BaseURL    string
 	APIToken   string
 	HTTPClient *http.Client
+
+	// DryRun disables all changes to paperless-ngx. Instead, the changes are logged and appended to DryRunReportPath.
+	DryRun           bool
+	DryRunReportPath string
+	reportMutex      sync.Mutex
+
+	// Journal records the state of every document before it is changed. Nil disables the journal.
+	Journal *journal.Journal
 }
 
-// NewPaperlessClient creates a new instance of PaperlessClient with a default HTTP client
-func NewPaperlessClient(baseURL, apiToken string) *PaperlessClient {
+// UpdateAppliedError reports a failure after the update of a document was applied, e.g. adding its summary as note.
+// Processing the document again would repeat the update, so it does not count as failed processing.
+type UpdateAppliedError struct {
+	DocumentID int
+	Err        error
+}
+
+func (err *UpdateAppliedError) Error() string {
+	return fmt.Sprintf("document %d was updated, but %v", err.DocumentID, err.Err)
+}
+
+func (err *UpdateAppliedError) Unwrap() error {
+	return err.Err
+}
+
+// NewPaperlessClient creates a new instance of PaperlessClient with an HTTP client that sends
+// at most maxConcurrency requests at the same time (unlimited if below 1) and aborts every request whose response
+// headers do not arrive within the timeout. Reading the body is only bounded by the context of the request, so
+// downloads of large documents are not aborted.
+func NewPaperlessClient(baseURL, apiToken string, maxConcurrency int, timeout time.Duration) *PaperlessClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
 
 	return &PaperlessClient{
-		BaseURL:    strings.TrimRight(baseURL, "/"),
-		APIToken:   apiToken,
-		HTTPClient: &http.Client{},
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+		APIToken: apiToken,
+		HTTPClient: &http.Client{
+			Transport: &limitedTransport{
+				base:    transport,
+				limiter: limiter.New(maxConcurrency),
+			},
+		},
 	}
 }
 
+// limitedTransport bounds the number of requests waiting for a response of paperless-ngx.
+// The slot is released once the response headers arrived, so reading a body never blocks other requests.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter *limiter.Limiter
+}
+
+func (transport *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := transport.limiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	defer transport.limiter.Release()
+
+	return transport.base.RoundTrip(req)
+}
+
 // Do method to make requests to the Paperless-NGX API
 func (paperlessClient *PaperlessClient) Do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
 	url := fmt.Sprintf("%s/%s", paperlessClient.BaseURL, strings.TrimLeft(path, "/"))
@@ -101,68 +154,172 @@ func (paperlessClient *PaperlessClient) GetAllTags(ctx context.Context) (map[str
 	return tagIDMapping, nil
 }
 
+// CreateTag creates a new tag in Paperless-NGX, which is never assigned automatically
+func (paperlessClient *PaperlessClient) CreateTag(ctx context.Context, name string) (int, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{"name": name, "matching_algorithm": 0})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := paperlessClient.Do(ctx, "POST", "api/tags/", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error creating tag: %d, %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	var createdTag struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&createdTag); err != nil {
+		return 0, err
+	}
+	return createdTag.ID, nil
+}
+
 // GetDocumentsByTags retrieves documents that match the specified tags
 func (paperlessClient *PaperlessClient) GetDocumentsByTags(ctx context.Context, tags []string, pageSize int) ([]paperless_model.Document, error) {
+	documents, _, err := paperlessClient.GetDocumentsByTagsPage(ctx, tags, 1, pageSize)
+	return documents, err
+}
+
+// GetDocumentsByTagsPage retrieves one page of the documents that match the specified tags and reports whether more pages exist
+func (paperlessClient *PaperlessClient) GetDocumentsByTagsPage(ctx context.Context, tags []string, page int, pageSize int) ([]paperless_model.Document, bool, error) {
 	tagQueries := make([]string, len(tags))
 	for i, tag := range tags {
 		tagQueries[i] = fmt.Sprintf("tag:%s", tag)
 	}
 	searchQuery := strings.Join(tagQueries, " ")
-	path := fmt.Sprintf("api/documents/?query=%s&page_size=%d&ordering=added", urlEncode(searchQuery), pageSize)
+	path := fmt.Sprintf("api/documents/?query=%s&page=%d&page_size=%d&ordering=added", urlEncode(searchQuery), page, pageSize)
+	return paperlessClient.getDocumentsPage(ctx, path)
+}
 
-	resp, err := paperlessClient.Do(ctx, "GET", path, nil)
+// GetSimilarDocuments retrieves up to count documents which are most similar to the specified document, the most similar first
+func (paperlessClient *PaperlessClient) GetSimilarDocuments(ctx context.Context, documentID int, count int) ([]paperless_model.Document, error) {
+	path := fmt.Sprintf("api/documents/?more_like_id=%d&page_size=%d", documentID, count)
+	documents, _, err := paperlessClient.getDocumentsPage(ctx, path)
+	return documents, err
+}
+
+// GetDocumentsModifiedSince retrieves one page of the documents modified after the given time, ordered by id,
+// and reports whether more pages exist
+func (paperlessClient *PaperlessClient) GetDocumentsModifiedSince(ctx context.Context, since time.Time, page int, pageSize int) ([]paperless_model.Document, bool, error) {
+	path := fmt.Sprintf("api/documents/?modified__gt=%s&page=%d&page_size=%d&ordering=id", urlEncode(since.UTC().Format(time.RFC3339)), page, pageSize)
+	return paperlessClient.getDocumentsPage(ctx, path)
+}
+
+// GetDocumentsByIDs retrieves the specified documents in the given order. Documents which do not exist are left out.
+func (paperlessClient *PaperlessClient) GetDocumentsByIDs(ctx context.Context, documentIDs []int) ([]paperless_model.Document, error) {
+	if len(documentIDs) == 0 {
+		return []paperless_model.Document{}, nil
+	}
+
+	ids := make([]string, len(documentIDs))
+	for i, documentID := range documentIDs {
+		ids[i] = strconv.Itoa(documentID)
+	}
+	path := fmt.Sprintf("api/documents/?id__in=%s&page_size=%d", strings.Join(ids, ","), len(documentIDs))
+	found, _, err := paperlessClient.getDocumentsPage(ctx, path)
 	if err != nil {
 		return nil, err
 	}
+
+	byID := make(map[int]paperless_model.Document, len(found))
+	for _, document := range found {
+		byID[document.ID] = document
+	}
+	documents := make([]paperless_model.Document, 0, len(found))
+	for _, documentID := range documentIDs {
+		if document, exists := byID[documentID]; exists {
+			documents = append(documents, document)
+		}
+	}
+	return documents, nil
+}
+
+// GetAllDocumentIDs retrieves the ids of all documents
+func (paperlessClient *PaperlessClient) GetAllDocumentIDs(ctx context.Context) ([]int, error) {
+	resp, err := paperlessClient.Do(ctx, "GET", "api/documents/?page_size=1", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error fetching document ids: %d, %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// paperless-ngx lists the ids of all matching documents with every page
+	var documentsResponse paperless_model.GetDocumentsApiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&documentsResponse); err != nil {
+		return nil, err
+	}
+	if len(documentsResponse.All) != documentsResponse.Count {
+		return nil, fmt.Errorf("error fetching document ids: got %d ids of %d documents", len(documentsResponse.All), documentsResponse.Count)
+	}
+	return documentsResponse.All, nil
+}
+
+// getDocumentsPage retrieves a page of a document list with resolved names and reports whether more pages exist
+func (paperlessClient *PaperlessClient) getDocumentsPage(ctx context.Context, path string) ([]paperless_model.Document, bool, error) {
+	resp, err := paperlessClient.Do(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, false, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("error searching documents: %d, %s", resp.StatusCode, string(bodyBytes))
+		return nil, false, fmt.Errorf("error searching documents: %d, %s", resp.StatusCode, string(bodyBytes))
 	}
 
 	var documentsResponse paperless_model.GetDocumentsApiResponse
 	err = json.NewDecoder(resp.Body).Decode(&documentsResponse)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	allTags, err := paperlessClient.GetAllTags(ctx)
+	lookup, err := paperlessClient.newNameLookup(ctx)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	documents := make([]paperless_model.Document, 0, len(documentsResponse.Results))
 	for _, result := range documentsResponse.Results {
-		tagNames := make([]string, len(result.Tags))
-		for i, resultTagID := range result.Tags {
-			for tagName, tagID := range allTags {
-				if resultTagID == tagID {
-					tagNames[i] = tagName
-					break
-				}
-			}
-		}
+		documents = append(documents, lookup.toDocument(result))
+	}
 
-		customFields := make([]paperless_model.CustomField, len(result.CustomFields))
-		for i, customField := range result.CustomFields {
-			customFields[i] = paperless_model.CustomField{
-				Value: customField.Value,
-				Field: customField.Field,
-			}
-		}
+	return documents, documentsResponse.Next != nil, nil
+}
 
-		documents = append(documents, paperless_model.Document{
-			ID:               result.ID,
-			Title:            result.Title,
-			Content:          result.Content,
-			Tags:             tagNames,
-			OriginalFileName: result.OriginalFileName,
-			CustomFields:     customFields,
-		})
+// AddNote adds a note to the specified document
+func (paperlessClient *PaperlessClient) AddNote(ctx context.Context, documentID int, note string) error {
+	if paperlessClient.DryRun {
+		return paperlessClient.reportDryRun(newNoteDiff(documentID, note))
 	}
 
-	return documents, nil
+	jsonData, err := json.Marshal(map[string]string{"note": note})
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("api/documents/%d/notes/", documentID)
+	resp, err := paperlessClient.Do(ctx, "POST", path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error adding note to document %d: %d, %s", documentID, resp.StatusCode, string(bodyBytes))
+	}
+	return nil
 }
 
 // DownloadPDF downloads the PDF file of the specified document
@@ -183,55 +340,139 @@ func (paperlessClient *PaperlessClient) DownloadPDF(ctx context.Context, documen
 }
 
 func (paperlessClient *PaperlessClient) GetDocument(ctx context.Context, documentID int) (paperless_model.Document, error) {
+	documentResponse, err := paperlessClient.getDocumentResponse(ctx, documentID)
+	if err != nil {
+		return paperless_model.Document{}, err
+	}
+
+	lookup, err := paperlessClient.newNameLookup(ctx)
+	if err != nil {
+		return paperless_model.Document{}, err
+	}
+
+	return lookup.toDocument(documentResponse), nil
+}
+
+// getDocumentResponse fetches a document as returned by the paperless-ngx api, with ids instead of names
+func (paperlessClient *PaperlessClient) getDocumentResponse(ctx context.Context, documentID int) (paperless_model.GetDocumentApiResponse, error) {
 	path := fmt.Sprintf("api/documents/%d/", documentID)
 	resp, err := paperlessClient.Do(ctx, "GET", path, nil)
 	if err != nil {
-		return paperless_model.Document{}, err
+		return paperless_model.GetDocumentApiResponse{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return paperless_model.Document{}, fmt.Errorf("error fetching document %d: %d, %s", documentID, resp.StatusCode, string(bodyBytes))
+		return paperless_model.GetDocumentApiResponse{}, fmt.Errorf("error fetching document %d: %d, %s", documentID, resp.StatusCode, string(bodyBytes))
 	}
 
 	var documentResponse paperless_model.GetDocumentApiResponse
 	err = json.NewDecoder(resp.Body).Decode(&documentResponse)
 	if err != nil {
-		return paperless_model.Document{}, err
+		return paperless_model.GetDocumentApiResponse{}, err
 	}
+	return documentResponse, nil
+}
+
+// nameLookup resolves the ids of tags, correspondents and document types to their names
+type nameLookup struct {
+	tags           map[string]int
+	correspondents map[string]int
+	documentTypes  map[string]int
+}
 
+// newNameLookup fetches all tags, correspondents and document types for resolving their ids
+func (paperlessClient *PaperlessClient) newNameLookup(ctx context.Context) (*nameLookup, error) {
 	allTags, err := paperlessClient.GetAllTags(ctx)
 	if err != nil {
-		return paperless_model.Document{}, err
+		return nil, err
 	}
 
-	tagNames := make([]string, len(documentResponse.Tags))
-	for i, resultTagID := range documentResponse.Tags {
-		for tagName, tagID := range allTags {
-			if resultTagID == tagID {
-				tagNames[i] = tagName
-				break
-			}
-		}
+	allCorrespondents, err := paperlessClient.GetAllCorrespondents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allDocumentTypes, err := paperlessClient.GetAllDocumentTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nameLookup{
+		tags:           allTags,
+		correspondents: allCorrespondents,
+		documentTypes:  allDocumentTypes,
+	}, nil
+}
+
+// toDocument converts a document of the paperless-ngx api to a document with resolved names
+func (lookup *nameLookup) toDocument(response paperless_model.GetDocumentApiResponse) paperless_model.Document {
+	tagNames := make([]string, len(response.Tags))
+	for i, resultTagID := range response.Tags {
+		tagNames[i] = nameForID(lookup.tags, resultTagID)
 	}
 
-	customFields := make([]paperless_model.CustomField, len(documentResponse.CustomFields))
-	for i, customField := range documentResponse.CustomFields {
+	customFields := make([]paperless_model.CustomField, len(response.CustomFields))
+	for i, customField := range response.CustomFields {
 		customFields[i] = paperless_model.CustomField{
 			Value: customField.Value,
 			Field: customField.Field,
 		}
 	}
 
-	return paperless_model.Document{
-		ID:               documentResponse.ID,
-		Title:            documentResponse.Title,
-		Content:          documentResponse.Content,
+	document := paperless_model.Document{
+		ID:               response.ID,
+		Title:            response.Title,
+		Content:          response.Content,
 		Tags:             tagNames,
-		OriginalFileName: documentResponse.OriginalFileName,
+		CreatedDate:      response.CreatedDate,
+		OriginalFileName: response.OriginalFileName,
 		CustomFields:     customFields,
-	}, nil
+	}
+
+	if document.CreatedDate == "" && !response.Created.IsZero() {
+		document.CreatedDate = response.Created.Format("2006-01-02")
+	}
+	if response.Correspondent != nil {
+		document.Correspondent = nameForID(lookup.correspondents, *response.Correspondent)
+	}
+	if response.DocumentType != nil {
+		document.DocumentType = nameForID(lookup.documentTypes, *response.DocumentType)
+	}
+
+	return document
+}
+
+// nameForID returns the name which is mapped to the given id or an empty string
+func nameForID(mapping map[string]int, id int) string {
+	for name, mappedID := range mapping {
+		if mappedID == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// DownloadThumbnail downloads the preview thumbnail of the specified document and returns it with its content type
+func (paperlessClient *PaperlessClient) DownloadThumbnail(ctx context.Context, documentID int) ([]byte, string, error) {
+	path := fmt.Sprintf("api/documents/%d/thumb/", documentID)
+	resp, err := paperlessClient.Do(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, "", fmt.Errorf("error downloading thumbnail of document %d: %d, %s", documentID, resp.StatusCode, string(bodyBytes))
+	}
+
+	thumbnail, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return thumbnail, resp.Header.Get("Content-Type"), nil
 }
 
 // UpdateDocuments updates the specified documents with suggested changes
@@ -240,6 +481,7 @@ func (paperlessClient *PaperlessClient) UpdateDocument(ctx context.Context, sugg
 	documentID := suggestion.DocumentID
 
 	updatedFields := make(map[string]interface{})
+	createdEntities := []paperless_model.CreatedEntity{}
 
 	// Tags
 	if suggestion.Tags != nil {
@@ -252,19 +494,29 @@ func (paperlessClient *PaperlessClient) UpdateDocument(ctx context.Context, sugg
 
 	// Correspondent
 	if suggestion.Correspondent != nil {
-		if suggestedCorrespondentIdPointer, correspondentError := getSuggestedCorrespondent(ctx, *suggestion.Correspondent, paperlessClient); correspondentError != nil {
+		if suggestedCorrespondentIdPointer, created, correspondentError := getSuggestedCorrespondent(ctx, *suggestion.Correspondent, paperlessClient); correspondentError != nil {
 			return correspondentError
-		} else if suggestedCorrespondentIdPointer != nil {
-			updatedFields["correspondent"] = *suggestedCorrespondentIdPointer
+		} else {
+			if suggestedCorrespondentIdPointer != nil {
+				updatedFields["correspondent"] = *suggestedCorrespondentIdPointer
+			}
+			if created {
+				createdEntities = append(createdEntities, newCreatedEntity(paperless_model.EntityTypeCorrespondent, *suggestion.Correspondent, suggestedCorrespondentIdPointer))
+			}
 		}
 	}
 
 	// Document Type
 	if suggestion.DocumentType != nil {
-		if suggestedDocumentTypeIdPointer, documentTypeError := getSuggestedDocumentType(ctx, *suggestion.DocumentType, paperlessClient); documentTypeError != nil {
+		if suggestedDocumentTypeIdPointer, created, documentTypeError := getSuggestedDocumentType(ctx, *suggestion.DocumentType, paperlessClient); documentTypeError != nil {
 			return documentTypeError
-		} else if suggestedDocumentTypeIdPointer != nil {
-			updatedFields["document_type"] = *suggestedDocumentTypeIdPointer
+		} else {
+			if suggestedDocumentTypeIdPointer != nil {
+				updatedFields["document_type"] = *suggestedDocumentTypeIdPointer
+			}
+			if created {
+				createdEntities = append(createdEntities, newCreatedEntity(paperless_model.EntityTypeDocumentType, *suggestion.DocumentType, suggestedDocumentTypeIdPointer))
+			}
 		}
 	}
 
@@ -283,40 +535,103 @@ func (paperlessClient *PaperlessClient) UpdateDocument(ctx context.Context, sugg
 		updatedFields["content"] = *suggestion.Content
 	}
 
-	// Fetch all custom fields
-	customFields, err := paperlessClient.GetAllCustomFields(ctx)
-	if err != nil {
-		return err
+	// Stamp the audit custom field, if the pipeline has one, and record the detected language
+	writesLanguage := config.LanguageCustomField != "" && suggestion.Language != nil
+	if customFieldName != "" || writesLanguage {
+		// Fetch all custom fields
+		customFields, err := paperlessClient.GetAllCustomFields(ctx)
+		if err != nil {
+			return err
+		}
+
+		var updatedCustomFields []map[string]interface{}
+		if customFieldName != "" {
+			// Find the ID of the custom field with the name "auto_tagged"
+			autoTaggedFieldID, exists := customFields[customFieldName]
+			if !exists {
+				return fmt.Errorf("a custom field with the name: '%s' does not exist in paperless-ngx and must be created with the type: 'DATE' ", customFieldName)
+			}
+
+			currentDate := time.Now().Format("2006-01-02")
+			updatedCustomFields = append(updatedCustomFields, map[string]interface{}{
+				"field": autoTaggedFieldID,
+				"value": currentDate,
+			})
+		}
+		if writesLanguage {
+			languageFieldID, exists := customFields[config.LanguageCustomField]
+			if !exists {
+				return fmt.Errorf("a custom field with the name: '%s' does not exist in paperless-ngx and must be created with the type: 'TEXT' ", config.LanguageCustomField)
+			}
+			updatedCustomFields = append(updatedCustomFields, map[string]interface{}{
+				"field": languageFieldID,
+				"value": *suggestion.Language,
+			})
+		}
+
+		// add all  existing CustomFields
+		for _, customField := range suggestion.OriginalDocument.CustomFields {
+			if !containsCustomField(updatedCustomFields, customField.Field) {
+				updatedCustomFields = append(updatedCustomFields, map[string]interface{}{
+					"field": customField.Field,
+					"value": customField.Value,
+				})
+			}
+		}
+		updatedFields["custom_fields"] = updatedCustomFields
 	}
 
-	// Find the ID of the custom field with the name "auto_tagged"
-	autoTaggedFieldID, exists := customFields[customFieldName]
-	if !exists {
-		return fmt.Errorf("a custom field with the name: '%s' does not exist in paperless-ngx and must be created with the type: 'DATE' ", customFieldName)
+	if paperlessClient.DryRun {
+		return paperlessClient.reportDryRun(newSuggestionDiff(suggestion, updatedFields, createdEntities))
 	}
 
-	currentDate := time.Now().Format("2006-01-02")
-	updatedFields["custom_fields"] = []map[string]interface{}{
-		{
-			"field": autoTaggedFieldID, // Replace with the actual field ID
-			"value": currentDate,
-		},
+	origin := journal.Origin{
+		Source:          journal.SourceSuggestion,
+		Model:           suggestion.Model,
+		PromptVersion:   suggestion.PromptVersion,
+		CreatedEntities: createdEntities,
 	}
-	// add all  existing CustomFields
-	for _, customField := range suggestion.OriginalDocument.CustomFields {
-		if customField.Field != autoTaggedFieldID {
-			updatedFields["custom_fields"] = append(updatedFields["custom_fields"].([]map[string]interface{}), map[string]interface{}{
-				"field": customField.Field,
-				"value": customField.Value,
-			})
+	if updateError := paperlessClient.updateDocument(ctx, updatedFields, documentID, origin); updateError != nil {
+		return updateError
+	}
+
+	if suggestion.Summary != nil && strings.TrimSpace(*suggestion.Summary) != "" {
+		if noteError := paperlessClient.AddNote(ctx, documentID, *suggestion.Summary); noteError != nil {
+			return &UpdateAppliedError{DocumentID: documentID, Err: fmt.Errorf("adding the summary failed: %w", noteError)}
+		}
+	}
+
+	log.Printf("Document %d updated successfully.", documentID)
+	return nil
+}
+
+// containsCustomField reports whether the custom fields of an update contain the field with the given id
+func containsCustomField(customFields []map[string]interface{}, fieldID int) bool {
+	for _, customField := range customFields {
+		if customField["field"] == fieldID {
+			return true
 		}
 	}
+	return false
+}
+
+// UpdateDocumentTags replaces the tags of the specified document without changing any other field
+func (paperlessClient *PaperlessClient) UpdateDocumentTags(ctx context.Context, documentID int, tags []string) error {
+	tagIds, err := getSuggestedTags(ctx, paperlessClient, tags)
+	if err != nil {
+		return err
+	}
+
+	updatedFields := map[string]interface{}{"tags": tagIds}
+	if paperlessClient.DryRun {
+		return paperlessClient.reportDryRun(newTagsDiff(documentID, tags, updatedFields))
+	}
 
-	if updateError := paperlessClient.updateDocument(ctx, updatedFields, documentID); updateError != nil {
+	if updateError := paperlessClient.updateDocument(ctx, updatedFields, documentID, journal.Origin{Source: journal.SourceTags}); updateError != nil {
 		return updateError
 	}
 
-	log.Printf("Document %d updated successfully.", documentID)
+	log.Debugf("Tags of document %d updated to %v", documentID, tags)
 	return nil
 }
 
@@ -341,31 +656,38 @@ func getSuggestedTags(ctx context.Context, paperlessClient *PaperlessClient, sug
 	return suggestedTagIds, nil
 }
 
-func getSuggestedDocumentType(ctx context.Context, suggestedDocumentType string, paperlessClient *PaperlessClient) (*int, error) {
+// getSuggestedDocumentType resolves the id of a document type and creates it if it doesn't exist.
+// It reports whether the document type was created, or would have been created in dry-run mode.
+func getSuggestedDocumentType(ctx context.Context, suggestedDocumentType string, paperlessClient *PaperlessClient) (*int, bool, error) {
 	if suggestedDocumentType == "" {
-		return nil, nil
+		return nil, false, nil
 	}
 
 	availableDocumentTypes := make(map[string]int)
 	availableDocumentTypes, err := paperlessClient.GetAllDocumentTypes(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching available document types: %v", err)
+		return nil, false, fmt.Errorf("error fetching available document types: %v", err)
 	}
 
 	// Check if the suggested document type already exists
 	if documentTypeID, exists := availableDocumentTypes[suggestedDocumentType]; exists {
-		return &documentTypeID, nil
+		return &documentTypeID, false, nil
+	}
+
+	if paperlessClient.DryRun {
+		log.Infof("Dry run: would create document_type with name '%s'", suggestedDocumentType)
+		return nil, true, nil
 	}
 
 	// Create a new document type if it doesn't exist
 	newDocumentType := instantiateDocumentType(suggestedDocumentType)
 	newDocumentTypeID, err := paperlessClient.CreateDocumentType(ctx, newDocumentType)
 	if err != nil {
-		return nil, fmt.Errorf("error creating document type with name %s: %v", suggestedDocumentType, err)
+		return nil, false, fmt.Errorf("error creating document type with name %s: %v", suggestedDocumentType, err)
 	}
 
 	log.Warnf("Created document_type with name '%s' (id: %d)", suggestedDocumentType, newDocumentTypeID)
-	return &newDocumentTypeID, nil
+	return &newDocumentTypeID, true, nil
 }
 
 // instantiateDocumentType creates a new DocumentType object with default values
@@ -413,31 +735,38 @@ func (paperlessClient *PaperlessClient) CreateDocumentType(ctx context.Context,
 	return createdDocumentType.ID, nil
 }
 
-func getSuggestedCorrespondent(ctx context.Context, suggestedCorrespondent string, paperlessClient *PaperlessClient) (*int, error) {
+// getSuggestedCorrespondent resolves the id of a correspondent and creates it if it doesn't exist.
+// It reports whether the correspondent was created, or would have been created in dry-run mode.
+func getSuggestedCorrespondent(ctx context.Context, suggestedCorrespondent string, paperlessClient *PaperlessClient) (*int, bool, error) {
 	if suggestedCorrespondent == "" {
-		return nil, nil
+		return nil, false, nil
 	}
 
 	availableCorrespondents := make(map[string]int)
 	availableCorrespondents, err := paperlessClient.GetAllCorrespondents(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching available correspondents: %v", err)
+		return nil, false, fmt.Errorf("error fetching available correspondents: %v", err)
 	}
 
 	// Check if the suggested correspondent already exists
 	if correspondentID, exists := availableCorrespondents[suggestedCorrespondent]; exists {
-		return &correspondentID, nil
+		return &correspondentID, false, nil
+	}
+
+	if paperlessClient.DryRun {
+		log.Infof("Dry run: would create correspondent with name '%s'", suggestedCorrespondent)
+		return nil, true, nil
 	}
 
 	// Create a new correspondent if it doesn't exist
 	newCorrespondent := instantiateCorrespondent(suggestedCorrespondent)
 	newCorrespondentID, err := paperlessClient.CreateCorrespondent(ctx, newCorrespondent)
 	if err != nil {
-		return nil, fmt.Errorf("error creating correspondent with name %s: %v", suggestedCorrespondent, err)
+		return nil, false, fmt.Errorf("error creating correspondent with name %s: %v", suggestedCorrespondent, err)
 	}
 
 	log.Warnf("Created correspondent with name '%s'  (id: %d)", suggestedCorrespondent, newCorrespondentID)
-	return &newCorrespondentID, nil
+	return &newCorrespondentID, true, nil
 }
 
 func getSuggestedTitle(suggestedTitle string, originalTitle string, documentID int) string {
@@ -452,7 +781,14 @@ func getSuggestedTitle(suggestedTitle string, originalTitle string, documentID i
 	}
 }
 
-func (paperlessClient *PaperlessClient) updateDocument(ctx context.Context, updatedFields map[string]interface{}, documentID int) error {
+// updateDocument records the current state of the document in the journal and sends the PATCH request
+func (paperlessClient *PaperlessClient) updateDocument(ctx context.Context, updatedFields map[string]interface{}, documentID int, origin journal.Origin) error {
+	if paperlessClient.Journal != nil {
+		if err := paperlessClient.recordJournalEntry(ctx, updatedFields, documentID, origin); err != nil {
+			return fmt.Errorf("error recording document %d in journal, not updating it: %w", documentID, err)
+		}
+	}
+
 	// Marshal updated fields to JSON
 	jsonData, err := json.Marshal(updatedFields)
 	if err != nil {
@@ -477,6 +813,203 @@ func (paperlessClient *PaperlessClient) updateDocument(ctx context.Context, upda
 	return nil
 }
 
+// recordJournalEntry appends the current state of the document to the journal
+func (paperlessClient *PaperlessClient) recordJournalEntry(ctx context.Context, updatedFields map[string]interface{}, documentID int, origin journal.Origin) error {
+	current, err := paperlessClient.getDocumentResponse(ctx, documentID)
+	if err != nil {
+		return err
+	}
+
+	return paperlessClient.Journal.Append(journal.Entry{
+		Timestamp:  time.Now(),
+		DocumentID: documentID,
+		Origin:     origin,
+		Previous:   documentState(current),
+		Changes:    updatedFields,
+	})
+}
+
+// documentState returns the fields of a document which are recorded in the journal
+func documentState(document paperless_model.GetDocumentApiResponse) journal.DocumentState {
+	return journal.DocumentState{
+		Title:         document.Title,
+		Tags:          document.Tags,
+		Correspondent: document.Correspondent,
+		DocumentType:  document.DocumentType,
+		CreatedDate:   document.CreatedDate,
+		Content:       document.Content,
+		CustomFields:  document.CustomFields,
+	}
+}
+
+// restoreFields returns the given fields of a state recorded in the journal as fields of an update request
+func restoreFields(state journal.DocumentState, fields []string) map[string]interface{} {
+	updatedFields := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "title":
+			updatedFields[field] = state.Title
+		case "tags":
+			updatedFields[field] = state.Tags
+		case "correspondent":
+			updatedFields[field] = state.Correspondent
+		case "document_type":
+			updatedFields[field] = state.DocumentType
+		case "created_date":
+			if state.CreatedDate != "" {
+				updatedFields[field] = state.CreatedDate
+			}
+		case "content":
+			updatedFields[field] = state.Content
+		case "custom_fields":
+			customFields := make([]map[string]interface{}, 0, len(state.CustomFields))
+			for _, customField := range state.CustomFields {
+				customFields = append(customFields, map[string]interface{}{
+					"field": customField.Field,
+					"value": customField.Value,
+				})
+			}
+			updatedFields[field] = customFields
+		}
+	}
+	return updatedFields
+}
+
+// RestoreDocument sets the given fields of a document back to a state recorded in the journal. Other fields are
+// left as they are. In dry-run mode the current and the restored values of the fields are reported.
+func (paperlessClient *PaperlessClient) RestoreDocument(ctx context.Context, documentID int, state journal.DocumentState, fields []string) error {
+	updatedFields := restoreFields(state, fields)
+	if len(updatedFields) == 0 {
+		log.Infof("Document %d has no fields to restore.", documentID)
+		return nil
+	}
+
+	if paperlessClient.DryRun {
+		current, err := paperlessClient.getDocumentResponse(ctx, documentID)
+		if err != nil {
+			return err
+		}
+		return paperlessClient.reportDryRun(DocumentDiff{
+			Timestamp:  time.Now(),
+			DocumentID: documentID,
+			Before:     restoreFields(documentState(current), fields),
+			After:      updatedFields,
+			Patch:      updatedFields,
+		})
+	}
+
+	if err := paperlessClient.updateDocument(ctx, updatedFields, documentID, journal.Origin{Source: journal.SourceRollback}); err != nil {
+		return err
+	}
+
+	log.Infof("Document %d restored: %s.", documentID, strings.Join(fields, ", "))
+	return nil
+}
+
+// entityPaths maps the entity types which paperless-gpt creates to their api paths
+var entityPaths = map[string]string{
+	paperless_model.EntityTypeCorrespondent: "api/correspondents/",
+	paperless_model.EntityTypeDocumentType:  "api/document_types/",
+}
+
+// EntityDocumentCount returns the number of documents assigned to a correspondent or document type
+func (paperlessClient *PaperlessClient) EntityDocumentCount(ctx context.Context, entityType string, id int) (int, error) {
+	path := fmt.Sprintf("%s%d/", entityPaths[entityType], id)
+	resp, err := paperlessClient.Do(ctx, "GET", path, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("error fetching %s %d: %d, %s", entityType, id, resp.StatusCode, string(bodyBytes))
+	}
+
+	var entity struct {
+		DocumentCount int `json:"document_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entity); err != nil {
+		return 0, err
+	}
+	return entity.DocumentCount, nil
+}
+
+// GetDocumentCounts retrieves how many documents every tag, correspondent and document type is assigned to
+func (paperlessClient *PaperlessClient) GetDocumentCounts(ctx context.Context) (paperless_model.DocumentCounts, error) {
+	var counts paperless_model.DocumentCounts
+	var err error
+	if counts.Tags, err = paperlessClient.getDocumentCounts(ctx, "api/tags/?page_size=9999"); err != nil {
+		return counts, err
+	}
+	if counts.Correspondents, err = paperlessClient.getDocumentCounts(ctx, "api/correspondents/?page_size=9999"); err != nil {
+		return counts, err
+	}
+	if counts.DocumentTypes, err = paperlessClient.getDocumentCounts(ctx, "api/document_types/?page_size=9999"); err != nil {
+		return counts, err
+	}
+	return counts, nil
+}
+
+// getDocumentCounts retrieves the document count of every entity of a list endpoint by name, following all pages
+func (paperlessClient *PaperlessClient) getDocumentCounts(ctx context.Context, path string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for path != "" {
+		resp, err := paperlessClient.Do(ctx, "GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			bodyBytes, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("error fetching %s: %d, %s", path, resp.StatusCode, string(bodyBytes))
+		}
+
+		var entitiesResponse struct {
+			Results []struct {
+				Name          string `json:"name"`
+				DocumentCount int    `json:"document_count"`
+			} `json:"results"`
+			Next string `json:"next"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&entitiesResponse)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entity := range entitiesResponse.Results {
+			counts[entity.Name] = entity.DocumentCount
+		}
+		path = strings.TrimPrefix(entitiesResponse.Next, paperlessClient.BaseURL+"/")
+	}
+	return counts, nil
+}
+
+// DeleteEntity deletes a correspondent or document type
+func (paperlessClient *PaperlessClient) DeleteEntity(ctx context.Context, entityType string, id int) error {
+	if paperlessClient.DryRun {
+		log.Infof("Dry run: would delete %s %d", entityType, id)
+		return nil
+	}
+
+	path := fmt.Sprintf("%s%d/", entityPaths[entityType], id)
+	resp, err := paperlessClient.Do(ctx, "DELETE", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error deleting %s %d: %d, %s", entityType, id, resp.StatusCode, string(bodyBytes))
+	}
+
+	log.Infof("Deleted %s %d", entityType, id)
+	return nil
+}
+
 // urlEncode encodes a string for safe URL usage
 func urlEncode(s string) string {
 	return strings.ReplaceAll(s, " ", "+")